@@ -0,0 +1,398 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultAPIMaxBytes = 10 << 20
+	defaultAPITimeout  = 60 * time.Second
+)
+
+func apiCommand(args []string) error {
+	var address string
+	var maxBytes int64
+	var timeout time.Duration
+
+	flags := newCommandFlags("api", "[options]", "Serves an http api that validates plans, renders the label of a plan and returns a zip file of the labels of a batch of plans. Plans are posted as csv or json, see the README for the endpoints.")
+	addRenderFlags(flags)
+	addFileNameFlag(flags)
+	flags.StringVar(&zipName, "zipname", "generated-labels", "the name of the zip file returned by the batch endpoint")
+	flags.IntVar(&labelJobs, "jobs", runtime.NumCPU(), "the number of labels of a batch to render at the same time")
+	flags.StringVar(&address, "addr", "localhost:8081", "the address to listen on")
+	flags.Int64Var(&maxBytes, "maxbytes", defaultAPIMaxBytes, "the largest request body accepted, in bytes")
+	flags.DurationVar(&timeout, "timeout", defaultAPITimeout, "the longest a request may take, eg: 30s")
+	if err := parseCommandFlags(flags, args); err != nil {
+		return err
+	}
+
+	if err := setupRendering(); err != nil {
+		return err
+	}
+	if labelJobs < 1 {
+		return fmt.Errorf("-jobs must be at least 1, got %d", labelJobs)
+	}
+	if _, err := parseFileNamePattern(fileNamePattern); err != nil {
+		return err
+	}
+
+	// the plans of a request are never grouped or filtered
+	api := &labelAPI{
+		settings: labelSettings{groupBy: groupByNone, fileNamePattern: fileNamePattern},
+		maxBytes: maxBytes,
+		timeout:  timeout,
+	}
+	logInfo("serving the label api on http://%s", address)
+	server := &http.Server{
+		Addr:              address,
+		Handler:           api.handler(),
+		ReadHeaderTimeout: 10 * time.Second,
+		ReadTimeout:       timeout,
+		// the timeout handler answers first, this only ends stuck writes
+		WriteTimeout: timeout + 10*time.Second,
+	}
+	return server.ListenAndServe()
+}
+
+// apiError is an error with the http status it is answered with.
+type apiError struct {
+	status int
+	err    error
+}
+
+func (e apiError) Error() string {
+	return e.err.Error()
+}
+
+func newAPIError(status int, format string, args ...interface{}) apiError {
+	return apiError{status, fmt.Errorf(format, args...)}
+}
+
+// labelAPI serves the label api. The plans of each request are read with
+// settings rather than the settings of the flags of other commands.
+type labelAPI struct {
+	settings labelSettings
+	maxBytes int64
+	timeout  time.Duration
+}
+
+// apiRequest is the plans posted to an endpoint, written to a csv file in a
+// directory removed after the request.
+type apiRequest struct {
+	csvFile string
+	// inputFile names the body in the manifest, request.csv or request.json,
+	// and inputSHA256 is the checksum of the body
+	inputFile   string
+	inputSHA256 string
+}
+
+// apiHandlerFunc handles a request with the plans posted to it.
+type apiHandlerFunc func(w http.ResponseWriter, r *http.Request, request apiRequest) error
+
+func (a *labelAPI) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("/v1/validate", a.endpoint(a.handleValidate))
+	mux.Handle("/v1/render", a.endpoint(a.handleRender))
+	mux.Handle("/v1/batch", a.endpoint(a.handleBatch))
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		writeAPIError(w, newAPIError(http.StatusNotFound, "no endpoint %s, use /v1/validate, /v1/render or /v1/batch", r.URL.Path))
+	})
+
+	timeoutMessage := convertErrorToJSON("NA", fmt.Sprintf("the request took longer than %s", a.timeout)).Error()
+	return http.TimeoutHandler(mux, a.timeout, timeoutMessage)
+}
+
+// endpoint reads the plans posted to an endpoint into a csv file and answers
+// errors in the jsonError format.
+func (a *labelAPI) endpoint(handle apiHandlerFunc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			writeAPIError(w, newAPIError(http.StatusMethodNotAllowed, "%s takes a POST of csv or json plans", r.URL.Path))
+			return
+		}
+
+		dir, err := os.MkdirTemp("", "sonarbcd-api-")
+		if err != nil {
+			writeAPIError(w, err)
+			return
+		}
+		defer os.RemoveAll(dir)
+
+		request := apiRequest{csvFile: filepath.Join(dir, "plans.csv")}
+		hash := sha256.New()
+		body := io.TeeReader(http.MaxBytesReader(w, r.Body, a.maxBytes), hash)
+		request.inputFile, err = writeRequestCSV(body, r.Header.Get("Content-Type"), request.csvFile)
+		if err == nil {
+			// the checksum covers what is left after the json plans
+			_, err = io.Copy(io.Discard, body)
+		}
+		if err != nil {
+			writeAPIError(w, err)
+			return
+		}
+		request.inputSHA256 = hex.EncodeToString(hash.Sum(nil))
+
+		if info, err := os.Stat(request.csvFile); err != nil || info.Size() == 0 {
+			writeAPIError(w, newAPIError(http.StatusBadRequest, "the request has no plans"))
+			return
+		}
+		if err := handle(w, r, request); err != nil {
+			writeAPIError(w, err)
+		}
+	})
+}
+
+// writeAPIError answers with err in the jsonError format. Errors of the plans
+// are unprocessable and other errors are server errors, unless err is an
+// apiError with its own status.
+func writeAPIError(w http.ResponseWriter, err error) {
+	status := http.StatusInternalServerError
+	var apiErr apiError
+	var maxBytesErr *http.MaxBytesError
+	switch {
+	case errors.As(err, &apiErr):
+		status = apiErr.status
+	case errors.As(err, &maxBytesErr):
+		status = http.StatusRequestEntityTooLarge
+		err = fmt.Errorf("the request body is larger than %d bytes", maxBytesErr.Limit)
+	default:
+		if row, _ := errorMessage(err); row != "" {
+			status = http.StatusUnprocessableEntity
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	io.WriteString(w, errorToJSON(err).Error())
+}
+
+func writeAPIJSON(w http.ResponseWriter, value interface{}) error {
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(value)
+}
+
+// writeRequestCSV writes the plans of a request body to csvFile and returns
+// the name the body is known by. A text/csv body is written as it is, an
+// application/json body holds a plan or a list of plans keyed by csv column
+// name.
+func writeRequestCSV(body io.Reader, contentType, csvFile string) (string, error) {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	switch mediaType {
+	case "text/csv":
+		file, err := os.Create(csvFile)
+		if err != nil {
+			return "", err
+		}
+		if _, err := io.Copy(file, body); err != nil {
+			file.Close()
+			return "", err
+		}
+		return "request.csv", file.Close()
+	case "application/json":
+		contents, err := jsonPlansToCSV(body)
+		if err != nil {
+			return "", err
+		}
+		return "request.json", os.WriteFile(csvFile, contents, 0644)
+	default:
+		return "", newAPIError(http.StatusUnsupportedMediaType, "post the plans as text/csv or application/json, not %q", contentType)
+	}
+}
+
+// jsonPlansToCSV converts a json plan, or a list of them, to a csv file with
+// a column for each key used by any of the plans. Numbers are kept as they are
+// written, so 70.00 stays 70.00.
+func jsonPlansToCSV(body io.Reader) ([]byte, error) {
+	decoder := json.NewDecoder(body)
+	decoder.UseNumber()
+	var payload interface{}
+	if err := decoder.Decode(&payload); err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			return nil, err
+		}
+		return nil, newAPIError(http.StatusBadRequest, "the json plans can't be read: %v", err)
+	}
+
+	var plans []interface{}
+	switch payload := payload.(type) {
+	case []interface{}:
+		plans = payload
+	case map[string]interface{}:
+		plans = []interface{}{payload}
+	}
+	if len(plans) == 0 {
+		return nil, newAPIError(http.StatusBadRequest, "post a json plan or a list of json plans")
+	}
+
+	columns := make(map[string]bool)
+	rows := make([]map[string]string, len(plans))
+	for i, plan := range plans {
+		fields, ok := plan.(map[string]interface{})
+		if !ok {
+			return nil, newAPIError(http.StatusBadRequest, "plan %d isn't a json object", i+1)
+		}
+		rows[i] = make(map[string]string, len(fields))
+		for column, value := range fields {
+			switch value := value.(type) {
+			case nil:
+			case string:
+				rows[i][column] = value
+			case json.Number, bool:
+				rows[i][column] = fmt.Sprint(value)
+			default:
+				return nil, newAPIError(http.StatusBadRequest, "plan %d: %s must be a string, number or boolean", i+1, column)
+			}
+			columns[column] = true
+		}
+	}
+
+	header := make([]string, 0, len(columns))
+	for column := range columns {
+		header = append(header, column)
+	}
+	sort.Strings(header)
+
+	var contents bytes.Buffer
+	writer := csv.NewWriter(&contents)
+	writer.Write(header)
+	for _, row := range rows {
+		record := make([]string, len(header))
+		for i, column := range header {
+			record[i] = row[column]
+		}
+		writer.Write(record)
+	}
+	writer.Flush()
+	return contents.Bytes(), writer.Error()
+}
+
+// apiValidation is the answer of the validate endpoint, the errors are in the
+// jsonError format with the csv row, or the position in the list of json
+// plans counting the header as 1.
+type apiValidation struct {
+	Rows     int         `json:"rows"`
+	Valid    int         `json:"valid"`
+	Warnings []string    `json:"warnings"`
+	Errors   []jsonError `json:"errors"`
+}
+
+func (a *labelAPI) handleValidate(w http.ResponseWriter, r *http.Request, request apiRequest) error {
+	result, err := validateCSV(request.csvFile, a.settings)
+	if err != nil {
+		return newAPIError(http.StatusBadRequest, "the csv plans can't be read: %v", err)
+	}
+
+	answer := apiValidation{Rows: result.Rows, Warnings: result.Warnings, Errors: []jsonError{}}
+	if answer.Warnings == nil {
+		answer.Warnings = []string{}
+	}
+	for _, err := range result.Errors {
+		row, message := errorMessage(err)
+		answer.Errors = append(answer.Errors, jsonError{IsError: "true", Message: message, Row: row})
+	}
+	answer.Valid = answer.Rows - len(answer.Errors)
+	if answer.Valid < 0 {
+		answer.Valid = 0
+	}
+	return writeAPIJSON(w, answer)
+}
+
+// handleRender renders the label of a single plan as svg, or html with the svg
+// inline, chosen with ?format=.
+//
+// The request for this endpoint also asked for pdf. Labels are drawn by the
+// svg templates, which can be replaced with -templatedir, and turning them
+// into pdf needs an svg renderer that this program doesn't have and can't
+// call out to, so pdf is answered with 501 and a message saying so rather
+// than as an unknown format.
+func (a *labelAPI) handleRender(w http.ResponseWriter, r *http.Request, request apiRequest) error {
+	format := r.URL.Query().Get("format")
+	switch format {
+	case "", "svg", "html":
+	case "pdf":
+		return newAPIError(http.StatusNotImplemented, "pdf labels aren't rendered, render the svg and convert it, eg: with rsvg-convert -f pdf")
+	default:
+		return newAPIError(http.StatusBadRequest, "unknown format %q, expected svg or html", format)
+	}
+
+	plans, err := loadPlans(request.csvFile)
+	if err != nil {
+		return err
+	}
+	if len(plans) != 1 {
+		return newAPIError(http.StatusBadRequest, "render takes a single plan, got %d, use /v1/batch for more", len(plans))
+	}
+
+	// a plan that can't be rendered is answered like an invalid plan
+	var label bytes.Buffer
+	if err := renderLabel(&label, plans[0]); err != nil {
+		return convertErrorToJSON(strconv.Itoa(plans[0].CsvRow), err.Error())
+	}
+
+	if format == "html" {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		_, err = fmt.Fprintf(w, "<!DOCTYPE html>\n<html lang=\"en\">\n<head><meta charset=\"utf-8\"><title>%s</title></head>\n<body>\n%s\n</body>\n</html>\n", uniquePlanID(plans[0]), label.Bytes())
+		return err
+	}
+	w.Header().Set("Content-Type", "image/svg+xml")
+	_, err = w.Write(label.Bytes())
+	return err
+}
+
+// handleBatch writes the labels of the plans as generate does and answers
+// with the zip file, including its manifest.
+func (a *labelAPI) handleBatch(w http.ResponseWriter, r *http.Request, request apiRequest) error {
+	stage := filepath.Join(filepath.Dir(request.csvFile), "labels")
+	if err := os.Mkdir(stage, 0755); err != nil {
+		return err
+	}
+	cache, err := newLabelCache(stage, outputState{})
+	if err != nil {
+		return err
+	}
+
+	dates, err := currentLabelDates()
+	if err != nil {
+		return err
+	}
+
+	groups, err := generateLabelsFromCSV(request.csvFile, stage, a.settings, cache)
+	if err != nil {
+		return err
+	}
+	if len(groups) != 1 || len(groups[0].labels) == 0 {
+		return newAPIError(http.StatusBadRequest, "post at least one plan")
+	}
+	group := groups[0]
+	if err := zipUpLabels(group, newLabelManifest(group, request.inputFile, request.inputSHA256, dates)); err != nil {
+		return err
+	}
+
+	zipFile := strings.TrimSuffix(group.zipName, ".zip") + ".zip"
+	contents, err := os.ReadFile(filepath.Join(stage, zipFile))
+	if err != nil {
+		return err
+	}
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": zipFile}))
+	_, err = w.Write(contents)
+	return err
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"strings"
+	"sync"
+)
+
+// cliCommand is one of the sonarbcd subcommands, each has its own flags and
+// help text on top of the global options.
+type cliCommand struct {
+	name    string
+	summary string
+	run     func(args []string) error
+}
+
+func cliCommands() []cliCommand {
+	return []cliCommand{
+		{"generate", "validate the csv file and write the labels and zip files (the default)", generateCommand},
+		{"validate", "check every row of the csv file and report all errors", validateCommand},
+		{"preview", "render the label of a single plan", previewCommand},
+		{"explain", "show how the values on the label of a plan are worked out", explainCommand},
+		{"diff", "compare the plans of two csv files", diffCommand},
+		{"init", "write a csv template and field reference to start from", initCommand},
+		{"serve", "preview the labels of a csv file in a browser", serveCommand},
+		{"api", "serve an http api that validates plans and renders labels", apiCommand},
+		{"publish", "write a static website of the labels to host", publishCommand},
+		{"templates", "export the built-in label templates", templatesCommand},
+	}
+}
+
+// runCLI runs the subcommand named by the first argument. Without one the
+// labels are generated, so `sonarbcd -inputcsv bcd.csv` works as it always
+// has.
+func runCLI(args []string) error {
+	name := "generate"
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		name, args = args[0], args[1:]
+	}
+
+	if name == "help" {
+		if len(args) == 0 {
+			printCLIUsage(os.Stdout)
+			return nil
+		}
+		name, args = args[0], []string{"-h"}
+	}
+
+	for _, command := range cliCommands() {
+		if command.name == name {
+			return command.run(args)
+		}
+	}
+	printCLIUsage(os.Stderr)
+	return fmt.Errorf("unknown command %q", name)
+}
+
+func printCLIUsage(w io.Writer) {
+	fmt.Fprintln(w, "usage: sonarbcd <command> [options]")
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "commands:")
+	for _, command := range cliCommands() {
+		fmt.Fprintf(w, "  %-10s %s\n", command.name, command.summary)
+	}
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Run `sonarbcd help <command>` for the options of a command.")
+}
+
+// newCommandFlags returns the flag set of a subcommand with the global options
+// already added.
+func newCommandFlags(name, arguments, description string) *flag.FlagSet {
+	flags := flag.NewFlagSet(name, flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "usage: sonarbcd %s %s\n\n%s\n\noptions:\n", name, arguments, description)
+		flags.PrintDefaults()
+	}
+	addGlobalFlags(flags)
+	return flags
+}
+
+const (
+	logFormatJSON = "json"
+	logFormatText = "text"
+)
+
+var logFormat = logFormatJSON
+var verbosity = 1
+
+func addGlobalFlags(flags *flag.FlagSet) {
+	flags.StringVar(&logFormat, "logformat", logFormatJSON, "the format of errors and messages written to stderr: json or text")
+	flags.IntVar(&verbosity, "verbosity", 1, "0 for errors only, 1 to add warnings and summaries, 2 to add a line per label")
+	flags.StringVar(&configFileName, "config", "", "a yaml or toml config file, defaults to sonarbcd.yaml or sonarbcd.toml in the working directory when there is one")
+}
+
+func addInputFlags(flags *flag.FlagSet) {
+	flags.StringVar(&csvFileName, "inputcsv", "bcd.csv", "the name of the csv file to convert")
+}
+
+// addRenderFlags adds the options that change what a label looks like.
+func addRenderFlags(flags *flag.FlagSet) {
+	flags.StringVar(&qrBaseURL, "qrbaseurl", "", "the base url of the hosted labels, when set a qr code linking to <qrbaseurl>/<unique plan id> is added to each label")
+	flags.BoolVar(&qrEnabled, "qrcode", true, "add a qr code to each label when -qrbaseurl is set")
+	flags.StringVar(&themeFileName, "theme", "", "the name of a json theme file with a logo and link and rule colors")
+	flags.StringVar(&layoutName, "layout", layoutVertical, "the label layout, vertical or horizontal")
+	flags.IntVar(&layoutColumns, "columns", 3, "the number of columns used by the horizontal layout, 2 or 3")
+	flags.StringVar(&templateDirectory, "templatedir", "", "a directory of .tmpl files that override the built-in label templates")
+}
+
+// addNamingFlags adds the options that decide the file name of each label.
+func addNamingFlags(flags *flag.FlagSet) {
+	addFileNameFlag(flags)
+	flags.StringVar(&groupBy, "groupby", groupByNone, "group the labels into one directory and zip file per company: none or company")
+}
+
+func addFileNameFlag(flags *flag.FlagSet) {
+	flags.StringVar(&fileNamePattern, "filename", defaultFileNamePattern, "the file name pattern of each label, eg: {{.Company}}_{{.PlanID}}, see the README for the available fields")
+}
+
+func addOutputFlags(flags *flag.FlagSet) {
+	flags.StringVar(&outputDirectory, "outputdir", "./generated-labels", "the name of the directory to output the generated files to")
+	flags.StringVar(&zipName, "zipname", "generated-labels", "the name of the zip file to output the generated files to")
+	flags.BoolVar(&pruneStale, "prune", false, "remove labels and zip files written by an earlier run that no longer match a row of the csv")
+	flags.BoolVar(&dryRun, "dryrun", false, "list the stale files -prune would remove and exit without writing anything")
+	flags.IntVar(&labelJobs, "jobs", runtime.NumCPU(), "the number of labels to render at the same time")
+}
+
+// parseCommandFlags parses the flags of a subcommand, fills in the ones not
+// given from the environment and config file, and checks the global options.
+func parseCommandFlags(flags *flag.FlagSet, args []string) error {
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if err := applyConfig(flags); err != nil {
+		return err
+	}
+	if logFormat != logFormatJSON && logFormat != logFormatText {
+		return fmt.Errorf("unknown logformat %q, expected %s or %s", logFormat, logFormatJSON, logFormatText)
+	}
+	return nil
+}
+
+// setupRendering loads the layout, templates and theme selected by the render
+// flags.
+func setupRendering() error {
+	var err error
+	labelLayoutConfig, err = newLabelLayout(layoutName, layoutColumns)
+	if err != nil {
+		return err
+	}
+
+	// the templates and theme are reloaded even when unset, so serve can drop
+	// the ones removed from the config file
+	if err := loadLabelTemplates(templateDirectory); err != nil {
+		return err
+	}
+
+	labelTheme = defaultLabelTheme()
+	if themeFileName != "" {
+		theme, err := loadLabelTheme(themeFileName)
+		if err != nil {
+			return err
+		}
+		labelTheme = theme
+	}
+	return nil
+}
+
+// logOutput serializes messages from the label workers.
+var logOutput = struct {
+	sync.Mutex
+	w io.Writer
+}{w: os.Stderr}
+
+// logError writes an error to stderr, in the jsonError format unless
+// -logformat is text.
+func logError(err error) {
+	if logFormat == logFormatText {
+		row, message := errorMessage(err)
+		if row != "" {
+			message = "csv row " + row + ": " + message
+		}
+		writeLog("error: " + message)
+		return
+	}
+	writeLog(errorToJSON(err).Error())
+}
+
+// logWarning and logInfo are shown from verbosity 1, logDetail from 2.
+func logWarning(format string, args ...interface{}) {
+	logMessage(1, "warning: ", format, args...)
+}
+
+func logInfo(format string, args ...interface{}) {
+	logMessage(1, "", format, args...)
+}
+
+func logDetail(format string, args ...interface{}) {
+	logMessage(2, "", format, args...)
+}
+
+func logMessage(level int, prefix, format string, args ...interface{}) {
+	if verbosity < level {
+		return
+	}
+	message := fmt.Sprintf(format, args...)
+	if logFormat == logFormatText {
+		writeLog(prefix + message)
+		return
+	}
+	j, _ := json.Marshal(jsonError{IsError: "false", Message: prefix + message})
+	writeLog(string(j))
+}
+
+func writeLog(line string) {
+	logOutput.Lock()
+	defer logOutput.Unlock()
+	fmt.Fprintln(logOutput.w, line)
+}
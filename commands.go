@@ -0,0 +1,325 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+)
+
+func validateCommand(args []string) error {
+	flags := newCommandFlags("validate", "[options]", "Checks every row of the csv file, including the label file names, and reports all errors without writing anything.")
+	addInputFlags(flags)
+	addNamingFlags(flags)
+	addFilterFlags(flags)
+	if err := parseCommandFlags(flags, args); err != nil {
+		return err
+	}
+
+	result, err := validateCSV(csvFileName, commandLabelSettings())
+	if err != nil {
+		return err
+	}
+	for _, warning := range result.Warnings {
+		logWarning("%s", warning)
+	}
+	for _, err := range result.Errors {
+		logError(err)
+	}
+	if len(result.Errors) > 0 {
+		return fmt.Errorf("%d of %d rows have errors", len(result.Errors), result.Rows)
+	}
+	logInfo("%d rows are valid", result.Rows)
+	return nil
+}
+
+// csvValidation is the outcome of checking every row of a csv file, the rows
+// skipped as comments are among the warnings.
+type csvValidation struct {
+	Rows     int
+	Warnings []string
+	Errors   []error
+}
+
+// validateCSV checks every row of a csv file selected by settings, with the
+// error of each invalid row in Errors. A csv file that can't be read is
+// returned as an error.
+func validateCSV(csvFile string, settings labelSettings) (csvValidation, error) {
+	var result csvValidation
+	reader, err := openFilteredCSV(csvFile, settings.filter)
+	if err != nil {
+		return result, err
+	}
+	defer reader.Close()
+	result.Warnings = checkCsvHeader(reader.header)
+	reader.skipComment = func(row int) {
+		result.Warnings = append(result.Warnings, commentRowWarning(row))
+	}
+
+	groups, err := newLabelGroups(settings.groupBy, "", settings.fileNamePattern)
+	if err != nil {
+		return result, err
+	}
+
+	for {
+		data, err := reader.next()
+		if errors.Is(err, io.EOF) {
+			return result, nil
+		}
+		if err != nil {
+			result.Errors = append(result.Errors, err)
+			return result, nil
+		}
+		result.Rows++
+
+		plan, err := newBroadbandData(data)
+		if err == nil {
+			_, _, err = groups.add(plan)
+			if err != nil {
+				err = convertErrorToJSON(data["csvrow"], err.Error())
+			}
+		}
+		if err != nil {
+			result.Errors = append(result.Errors, err)
+		}
+	}
+}
+
+// addPlanFlags adds the options selecting a single plan of the csv file.
+func addPlanFlags(flags *flag.FlagSet, planID *string, row *int) {
+	flags.StringVar(planID, "plan", "", "the unique plan identifier of the plan, eg: F12345000000000000051")
+	flags.IntVar(row, "row", 0, "the csv row of the plan, the header is row 1")
+}
+
+// findPlan reads the csv file up to the plan with the unique plan identifier
+// planID, or at row when planID is empty.
+func findPlan(csvFile, planID string, row int) (BroadbandData, error) {
+	if planID == "" && row < 2 {
+		return BroadbandData{}, fmt.Errorf("select a plan with -plan or a csv row of 2 or more with -row")
+	}
+
+	reader, err := openCSV(csvFile)
+	if err != nil {
+		return BroadbandData{}, err
+	}
+	defer reader.Close()
+
+	for {
+		data, err := reader.next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return BroadbandData{}, err
+		}
+
+		if planID != "" {
+			// only the plan identifier fields are needed to find the plan,
+			// the other rows don't have to be valid
+			fixedOrMobile := data["fixed_or_mobile"]
+			if fixedOrMobile == "" {
+				fixedOrMobile = "Fixed"
+			}
+			if uniquePlanID(BroadbandData{FixedOrMobile: fixedOrMobile, FccID: data["fcc_id"], DataServiceID: data["data_service_id"]}) != planID {
+				continue
+			}
+		} else if reader.row != row {
+			continue
+		}
+		return newBroadbandData(data)
+	}
+
+	if planID != "" {
+		return BroadbandData{}, fmt.Errorf("no plan %s in %s", planID, csvFile)
+	}
+	return BroadbandData{}, fmt.Errorf("%s has no csv row %d", csvFile, row)
+}
+
+func previewCommand(args []string) error {
+	var planID, outputFile string
+	var row int
+
+	flags := newCommandFlags("preview", "[options]", "Renders the svg label of a single plan, selected with -plan or -row, to a file or stdout.")
+	addInputFlags(flags)
+	addRenderFlags(flags)
+	addPlanFlags(flags, &planID, &row)
+	flags.StringVar(&outputFile, "o", "-", "the file to write the label to, - for stdout")
+	if err := parseCommandFlags(flags, args); err != nil {
+		return err
+	}
+
+	if err := setupRendering(); err != nil {
+		return err
+	}
+
+	plan, err := findPlan(csvFileName, planID, row)
+	if err != nil {
+		return err
+	}
+
+	if outputFile == "-" {
+		writer := bufio.NewWriter(os.Stdout)
+		if err := renderLabel(writer, plan); err != nil {
+			return err
+		}
+		return writer.Flush()
+	}
+
+	labelFile, err := os.Create(outputFile)
+	if err != nil {
+		return err
+	}
+	writer := bufio.NewWriter(labelFile)
+	err = renderLabel(writer, plan)
+	if err == nil {
+		err = writer.Flush()
+	}
+	if err != nil {
+		labelFile.Close()
+		return err
+	}
+	if err := labelFile.Close(); err != nil {
+		return err
+	}
+	logInfo("wrote the label of %s to %s", uniquePlanID(plan), outputFile)
+	return nil
+}
+
+func explainCommand(args []string) error {
+	var planID string
+	var row int
+
+	flags := newCommandFlags("explain", "[options]", "Shows the values on the label of a single plan, selected with -plan or -row, and the csv fields they are worked out from.")
+	addInputFlags(flags)
+	addNamingFlags(flags)
+	addPlanFlags(flags, &planID, &row)
+	if err := parseCommandFlags(flags, args); err != nil {
+		return err
+	}
+
+	plan, err := findPlan(csvFileName, planID, row)
+	if err != nil {
+		return err
+	}
+
+	fileNamer, err := newLabelFileNamer(fileNamePattern)
+	if err != nil {
+		return err
+	}
+	fileName, err := fileNamer.next(plan)
+	if err != nil {
+		return err
+	}
+
+	return explainPlan(os.Stdout, plan, fileName)
+}
+
+// explainPlan writes each value of the label with where it comes from.
+func explainPlan(w io.Writer, plan BroadbandData, fileName string) error {
+	table := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	line := func(name, value, reason string) {
+		fmt.Fprintf(table, "%s\t%s\t%s\n", name, value, reason)
+	}
+
+	fmt.Fprintf(w, "%s %s, csv row %d\n\n", plan.CompanyName, plan.DataServiceName, plan.CsvRow)
+
+	line("unique plan identifier", uniquePlanID(plan), fmt.Sprintf("%s for fixed_or_mobile %q, fcc_id %s, data_service_id %s padded to 15 digits", uniquePlanID(plan)[:1], plan.FixedOrMobile, plan.FccID, plan.DataServiceID))
+	line("file name", fileName, fmt.Sprintf("-filename %q", fileNamePattern))
+
+	switch {
+	case len(plan.PriceSchedule) > 0:
+		line("monthly price", "$"+plan.MonthlyPrice, "the price of the first promotional step")
+	default:
+		line("monthly price", "$"+plan.MonthlyPrice, "data_service_price "+plan.DataServicePrice)
+	}
+	if plan.BillingFrequencyInMonths != "1" {
+		line("billing period price", "$"+plan.BillingPeriodPrice, fmt.Sprintf("%s, billing_frequency_in_months %s times data_service_price %s", plan.BillingPeriodText, plan.BillingFrequencyInMonths, plan.DataServicePrice))
+	}
+
+	startMonth := 1
+	for _, step := range plan.PriceSchedule {
+		endMonth := startMonth + step.PeriodInMonths - 1
+		line(fmt.Sprintf("promotional step %d", step.StepNumber), "$"+step.PricePerMonth, fmt.Sprintf("months %d to %d", startMonth, endMonth))
+		startMonth = endMonth + 1
+	}
+	if len(plan.PriceSchedule) > 0 {
+		line("price after promotions", "$"+plan.DataServicePrice, fmt.Sprintf("from month %d, data_service_price", startMonth))
+	}
+
+	if plan.ContractDuration != "" {
+		line("contract", plan.ContractDuration+" months", "contract_duration")
+	}
+	line("download speed", plan.CalculatedDLSpeedInMbps+" Mbps", speedReason("dl_speed_in_kbps", plan.DLSpeedInKbps))
+	line("upload speed", plan.CalculatedULSpeedInMbps+" Mbps", speedReason("ul_speed_in_kbps", plan.ULSpeedInKbps))
+	line("latency", plan.LatencyInMs+" ms", "latency_in_ms")
+
+	for _, fee := range plan.ExtraMonthlyFields {
+		line("monthly fee", "$"+fee.ChargeValue, fmt.Sprintf("monthly_fee_name_%d %q", fee.FieldNumber, fee.ChargeName))
+	}
+	for _, fee := range plan.ExtraOneTimeFields {
+		line("one-time fee", "$"+fee.ChargeValue, fmt.Sprintf("one_time_fee_name_%d %q", fee.FieldNumber, fee.ChargeName))
+	}
+	if qrBaseURL != "" {
+		line("qr code", planURL(qrBaseURL, uniquePlanID(plan)), "-qrbaseurl followed by the unique plan identifier")
+	}
+
+	return table.Flush()
+}
+
+func speedReason(field, value string) string {
+	if strings.Contains(value, ".") {
+		return field + " " + value + ", a decimal value is already in Mbps"
+	}
+	return field + " " + value + " divided by 1000"
+}
+
+func initCommand(args []string) error {
+	var outputFile, referenceFile string
+	var overwrite bool
+
+	flags := newCommandFlags("init", "[options]", "Writes a csv template with every column sonarbcd reads and a commented example row, and a field reference describing each column.")
+	flags.StringVar(&outputFile, "o", "bcd.csv", "the csv template to write")
+	flags.StringVar(&referenceFile, "reference", "", "the markdown field reference to write, defaults to the template name ending in -fields.md")
+	flags.BoolVar(&overwrite, "force", false, "overwrite the files when they already exist")
+	if err := parseCommandFlags(flags, args); err != nil {
+		return err
+	}
+	if referenceFile == "" {
+		referenceFile = strings.TrimSuffix(outputFile, filepath.Ext(outputFile)) + "-fields.md"
+	}
+
+	for _, file := range []string{outputFile, referenceFile} {
+		if _, err := os.Stat(file); err == nil && !overwrite {
+			return fmt.Errorf("%s already exists, use -force to overwrite it", file)
+		}
+	}
+
+	var template bytes.Buffer
+	writer := csv.NewWriter(&template)
+	writer.Write(templateColumns())
+	writer.Write(templateExampleRow())
+	writer.Flush()
+	if err := os.WriteFile(outputFile, template.Bytes(), 0644); err != nil {
+		return err
+	}
+
+	var reference bytes.Buffer
+	reference.WriteString("# sonarbcd CSV Fields #\n\n")
+	fmt.Fprintf(&reference, "The columns of %s. Rows starting with %s are skipped with a warning, remove it from the example row to use it as a plan.\n\n", filepath.Base(outputFile), csvCommentPrefix)
+	if err := writeFieldReference(&reference); err != nil {
+		return err
+	}
+	if err := os.WriteFile(referenceFile, reference.Bytes(), 0644); err != nil {
+		return err
+	}
+
+	logInfo("wrote the csv template %s and the field reference %s", outputFile, referenceFile)
+	return nil
+}
@@ -0,0 +1,600 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRunCLIUnknownCommand(t *testing.T) {
+	if err := runCLI([]string{"bogus"}); err == nil || !strings.Contains(err.Error(), "bogus") {
+		t.Errorf("runCLI returned %v, expected an unknown command error", err)
+	}
+}
+
+func TestValidateCSV(t *testing.T) {
+	result, err := validateCSV("bcd.csv", commandLabelSettings())
+	if err != nil || len(result.Errors) != 0 || len(result.Warnings) != 0 || result.Rows != 8 {
+		t.Fatalf("validateCSV returned %+v, %v, expected the 8 valid rows of bcd.csv", result, err)
+	}
+
+	// every invalid row is reported, not just the first
+	csvFile := filepath.Join(t.TempDir(), "invalid.csv")
+	contents, err := os.ReadFile("bcd.csv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.SplitAfter(string(contents), "\n")
+	lines[3] = strings.Replace(lines[3], ",99.95,", ",99.9x,", 1)
+	lines[5] = lines[4]
+	writeTestFile(t, csvFile, strings.Join(lines, ""))
+
+	result, err = validateCSV(csvFile, commandLabelSettings())
+	if err != nil || result.Rows != 8 || len(result.Errors) != 2 {
+		t.Fatalf("validateCSV returned %+v, %v, expected 2 errors", result, err)
+	}
+	if !strings.Contains(result.Errors[0].Error(), `"row":"4"`) || !strings.Contains(result.Errors[1].Error(), `"row":"6"`) {
+		t.Errorf("expected the errors of csv rows 4 and 6, got %v", result.Errors)
+	}
+
+	// a row skipped as a comment is reported
+	lines[7] = csvCommentPrefix + lines[7]
+	writeTestFile(t, csvFile, strings.Join(lines, ""))
+	result, err = validateCSV(csvFile, commandLabelSettings())
+	if err != nil || result.Rows != 7 || len(result.Warnings) != 1 || !strings.Contains(result.Warnings[0], "CSV row 8 is skipped as a comment") {
+		t.Errorf("validateCSV returned %+v, %v, expected a warning for the comment row 8", result, err)
+	}
+}
+
+func TestDiffPlans(t *testing.T) {
+	oldPlans := []BroadbandData{
+		{CsvRow: 2, FixedOrMobile: "Fixed", FccID: "1", DataServiceID: "1", DataServicePrice: "50.00"},
+		{CsvRow: 3, FixedOrMobile: "Fixed", FccID: "1", DataServiceID: "2", DataServicePrice: "60.00"},
+	}
+	newPlans := []BroadbandData{
+		{CsvRow: 2, FixedOrMobile: "Fixed", FccID: "1", DataServiceID: "3", DataServicePrice: "70.00"},
+		{CsvRow: 3, FixedOrMobile: "Fixed", FccID: "1", DataServiceID: "1", DataServicePrice: "55.00"},
+	}
+
+	changes := diffPlans(oldPlans, newPlans)
+	if len(changes) != 3 {
+		t.Fatalf("expected 3 changes, got %+v", changes)
+	}
+	if changes[0].Change != planAdded || changes[0].PlanID != "F1000000000000003" {
+		t.Errorf("expected plan 3 to be added first, got %+v", changes[0])
+	}
+	// the plan moved to another row, only the price changed
+	if changes[1].Change != planChanged || len(changes[1].Fields) != 1 || changes[1].Fields[0] != (fieldChange{"DataServicePrice", "50.00", "55.00"}) {
+		t.Errorf("expected the price of plan 1 to change, got %+v", changes[1])
+	}
+	if changes[2].Change != planRemoved || changes[2].PlanID != "F1000000000000002" {
+		t.Errorf("expected plan 2 to be removed, got %+v", changes[2])
+	}
+}
+
+func TestDiffCalculatedFields(t *testing.T) {
+	oldPlan := BroadbandData{
+		DataServicePrice:        "70.00",
+		MonthlyPrice:            "70.00",
+		CalculatedDLSpeedInMbps: "100",
+		ExtraMonthlyFields:      []AdditionalCharges{{1, "Equipment rental", "10.00"}},
+	}
+	newPlan := oldPlan
+	newPlan.DataServicePrice = "75.00"
+	newPlan.MonthlyPrice = "75.00"
+	newPlan.ExtraMonthlyFields = []AdditionalCharges{{1, "Equipment rental", "12.00"}}
+
+	fields := diffFields(oldPlan, newPlan)
+	expected := []fieldChange{
+		{"DataServicePrice", "70.00", "75.00"},
+		{"MonthlyPrice", "70.00", "75.00"},
+		{"ExtraMonthlyFields", "Equipment rental $10.00", "Equipment rental $12.00"},
+	}
+	if len(fields) != len(expected) {
+		t.Fatalf("got the changes %+v, expected %+v", fields, expected)
+	}
+	for i := range expected {
+		if fields[i] != expected[i] {
+			t.Errorf("got the change %+v, expected %+v", fields[i], expected[i])
+		}
+	}
+
+	var report strings.Builder
+	diff := planDiff{Changes: []planChange{{PlanID: "F1000000000000001", Change: planChanged, Fields: fields}}}
+	if err := writePlanDiffJSON(&report, diff); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(report.String(), `"field": "MonthlyPrice"`) {
+		t.Errorf("the json report is missing the MonthlyPrice change:\n%s", report.String())
+	}
+}
+
+func TestHighlightChangedText(t *testing.T) {
+	oldLabel := "<svg id=\"bcd\">\n<text x=\"1\" y=\"10\">Monthly Price</text>\n<text x=\"9\" y=\"10\">$70.00</text>\n<text x=\"1\" y=\"20\">Fees</text>\n</svg>"
+	// the price changed and a line was added, moving the fees down
+	newLabel := "<svg id=\"bcd\">\n<text x=\"1\" y=\"10\">Monthly Price</text>\n<text x=\"9\" y=\"10\">$75.00</text>\n<text x=\"1\" y=\"20\">Contract</text>\n<text x=\"1\" y=\"30\">Fees</text>\n</svg>"
+
+	highlighted := highlightChangedText(newLabel, oldLabel)
+	var changed []string
+	for _, match := range labelTextPattern.FindAllStringSubmatch(highlighted, -1) {
+		if strings.Contains(match[1], `class="changed"`) {
+			changed = append(changed, match[2])
+		}
+	}
+	if strings.Join(changed, ",") != "$75.00,Contract" {
+		t.Errorf("highlighted %q, expected the new price and the added line", changed)
+	}
+	if !strings.HasPrefix(highlighted, "<svg id=\"bcd\">"+changedTextStyle) {
+		t.Errorf("the highlight style wasn't added after the svg element:\n%s", highlighted)
+	}
+
+	if highlightChangedText(oldLabel, oldLabel) != oldLabel {
+		t.Error("an unchanged label was modified")
+	}
+}
+
+func TestPreviewRows(t *testing.T) {
+	csvFile := filepath.Join(t.TempDir(), "invalid.csv")
+	contents, err := os.ReadFile("bcd.csv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.SplitAfter(string(contents), "\n")
+	lines[3] = strings.Replace(lines[3], ",99.95,", ",99.9x,", 1)
+	writeTestFile(t, csvFile, strings.Join(lines, ""))
+
+	rows, warnings, err := previewRows(csvFile)
+	if err != nil || len(warnings) > 0 {
+		t.Fatalf("previewRows returned %v and the warnings %v", err, warnings)
+	}
+	if len(rows) != 8 {
+		t.Fatalf("expected every row to be listed, got %d", len(rows))
+	}
+	for _, row := range rows {
+		if row.CsvRow == 4 && (row.Error == "" || strings.Contains(row.Error, "isError")) {
+			t.Errorf("expected the message of the csv row 4 error, got %q", row.Error)
+		}
+		if row.CsvRow != 4 && (row.Error != "" || row.PlanID == "") {
+			t.Errorf("csv row %d should be a valid plan, got %q", row.CsvRow, row.Error)
+		}
+	}
+}
+
+func TestWatchFiles(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "bcd.csv")
+	writeTestFile(t, file, "company_name\n")
+
+	changed := make(chan struct{}, 1)
+	stop := make(chan struct{})
+	defer close(stop)
+	go watchFiles(func() []string { return []string{file} }, 10*time.Millisecond, func() { changed <- struct{}{} }, stop)
+
+	time.Sleep(50 * time.Millisecond)
+	writeTestFile(t, file, "company_name\nSonar Software\n")
+	select {
+	case <-changed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the change to the file wasn't noticed")
+	}
+}
+
+func TestAPIValidate(t *testing.T) {
+	handler := testAPIHandler(1024)
+	post := func(contentType, body string) *httptest.ResponseRecorder {
+		response := httptest.NewRecorder()
+		request := httptest.NewRequest(http.MethodPost, "/v1/validate", strings.NewReader(body))
+		request.Header.Set("Content-Type", contentType)
+		handler.ServeHTTP(response, request)
+		return response
+	}
+
+	response := post("application/json", `[{"company_name": "Sonar Software", "data_service_price": 70.00}, {"data_service_price": "7x"}]`)
+	if response.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", response.Code, response.Body)
+	}
+	var validation apiValidation
+	if err := json.Unmarshal(response.Body.Bytes(), &validation); err != nil {
+		t.Fatal(err)
+	}
+	if validation.Rows != 2 || len(validation.Errors) == 0 || validation.Errors[len(validation.Errors)-1].Row != "3" {
+		t.Errorf("expected the second plan to fail as csv row 3, got %+v", validation)
+	}
+
+	for _, test := range []struct {
+		contentType, body string
+		status            int
+	}{
+		{"text/csv", strings.Repeat("a", 2048), http.StatusRequestEntityTooLarge},
+		{"text/plain", "company_name", http.StatusUnsupportedMediaType},
+		{"application/json", "{", http.StatusBadRequest},
+		{"text/csv", "", http.StatusBadRequest},
+	} {
+		response := post(test.contentType, test.body)
+		var answer jsonError
+		if err := json.Unmarshal(response.Body.Bytes(), &answer); err != nil || answer.IsError != "true" {
+			t.Errorf("expected a json error for %s, got %s", test.contentType, response.Body)
+		}
+		if response.Code != test.status {
+			t.Errorf("expected %d for %s, got %d", test.status, test.contentType, response.Code)
+		}
+	}
+}
+
+func TestPublishIndexAndSitemap(t *testing.T) {
+	plans, err := loadSitePlans("bcd.csv", "https://labels.example.com/bcd")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	companies := groupSitePlans(plans)
+	for i := 1; i < len(companies); i++ {
+		if strings.ToLower(companies[i-1].Name) > strings.ToLower(companies[i].Name) {
+			t.Errorf("expected the companies in order, got %s before %s", companies[i-1].Name, companies[i].Name)
+		}
+	}
+	listed := 0
+	for _, company := range companies {
+		for _, technology := range company.Technologies {
+			for _, plan := range technology.Plans {
+				if plan.CompanyName != company.Name || plan.FixedOrMobile != technology.Name {
+					t.Errorf("plan %s is listed under %s %s", plan.PlanID, company.Name, technology.Name)
+				}
+				listed++
+			}
+		}
+	}
+	if listed != len(plans) {
+		t.Errorf("expected every plan in the index once, got %d of %d", listed, len(plans))
+	}
+
+	var sitemap strings.Builder
+	if err := writeSitemap(&sitemap, "https://labels.example.com/bcd/", plans, time.Date(2026, 1, 2, 0, 0, 0, 0, time.UTC)); err != nil {
+		t.Fatal(err)
+	}
+	for _, location := range []string{"https://labels.example.com/bcd/", plans[0].URL} {
+		if !strings.Contains(sitemap.String(), "<loc>"+location+"</loc>") {
+			t.Errorf("expected %s in the sitemap, got %s", location, sitemap.String())
+		}
+	}
+	if !strings.Contains(sitemap.String(), "<lastmod>2026-01-02</lastmod>") {
+		t.Errorf("expected the pages dated 2026-01-02, got %s", sitemap.String())
+	}
+	sitemap.Reset()
+	if err := writeSitemap(&sitemap, "https://labels.example.com/bcd/", plans, defaultSourceDate); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(sitemap.String(), "lastmod") {
+		t.Errorf("expected the pages undated without SOURCE_DATE_EPOCH, got %s", sitemap.String())
+	}
+	if plans[0].URL != "https://labels.example.com/bcd/"+plans[0].PlanID+"/" {
+		t.Errorf("expected the page url to end in the unique plan identifier, got %s", plans[0].URL)
+	}
+
+	duplicated := filepath.Join(t.TempDir(), "duplicated.csv")
+	contents, err := os.ReadFile("bcd.csv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.SplitAfter(string(contents), "\n")
+	writeTestFile(t, duplicated, strings.Join(append(lines[:3], lines[2:]...), ""))
+	if _, err := loadSitePlans(duplicated, "https://labels.example.com"); err == nil {
+		t.Error("expected a duplicated unique plan identifier to fail")
+	}
+}
+
+func TestRowFilter(t *testing.T) {
+	defer func() { planFilter = rowFilter{} }()
+
+	for _, test := range []struct {
+		args []string
+		rows int
+	}{
+		{nil, 8},
+		{[]string{"-company", "live oak fiber"}, 2},
+		{[]string{"-rows", "2-3,9-"}, 3},
+		{[]string{"-fixedormobile", "mobile"}, 1},
+		{[]string{"-planid", "F65489000000000000010,M3554356000000000000033"}, 2},
+		{[]string{"-serviceid", "51", "-company", "Greystar"}, 1},
+		{[]string{"-where", "dl_speed_in_kbps >= 500000 or latency_in_ms < 20"}, 6},
+		{[]string{"-where", `data_service_name = "Northern Neck 100/100MBPS Fiber" and acp = yes`}, 1},
+	} {
+		flags := newCommandFlags("test", "", "")
+		addFilterFlags(flags)
+		if err := flags.Parse(test.args); err != nil {
+			t.Fatal(err)
+		}
+		result, err := validateCSV("bcd.csv", commandLabelSettings())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result.Rows != test.rows {
+			t.Errorf("%v: expected %d rows, got %d", test.args, test.rows, result.Rows)
+		}
+	}
+
+	for _, expression := range []string{"dl_speed_in_kbps >", "acp = yes latency_in_ms < 20", "= 5"} {
+		if _, err := parseFilterExpression(expression); err == nil {
+			t.Errorf("expected %q to fail", expression)
+		}
+	}
+	if _, err := parseRowRanges("1-4"); err == nil {
+		t.Error("expected the header row to be refused")
+	}
+
+	planFilter = rowFilter{where: []filterExpression{{{{"dl_speed", ">", "5"}}}}}
+	if _, err := openCSV("bcd.csv"); err == nil {
+		t.Error("expected an expression on a missing column to fail")
+	}
+}
+
+// testAPIHandler is the label api with the settings of apiCommand.
+func testAPIHandler(maxBytes int64) http.Handler {
+	api := &labelAPI{
+		settings: labelSettings{groupBy: groupByNone, fileNamePattern: defaultFileNamePattern},
+		maxBytes: maxBytes,
+		timeout:  time.Minute,
+	}
+	return api.handler()
+}
+
+func postAPI(handler http.Handler, target, contentType, body string) *httptest.ResponseRecorder {
+	response := httptest.NewRecorder()
+	request := httptest.NewRequest(http.MethodPost, target, strings.NewReader(body))
+	request.Header.Set("Content-Type", contentType)
+	handler.ServeHTTP(response, request)
+	return response
+}
+
+const testAPIPlan = `{"company_name": "Sonar Software", "fcc_id": "12345", "data_service_id": "100", "data_service_name": "MaxSpeed 100", "data_service_price": 70.00, "billing_frequency_in_months": 1, "dl_speed_in_kbps": 100000, "ul_speed_in_kbps": 20000, "latency_in_ms": 25}`
+
+func TestAPIRender(t *testing.T) {
+	useTestLayout(t)
+	handler := testAPIHandler(1 << 20)
+
+	for _, test := range []struct {
+		format, contentType, start string
+	}{
+		{"", "image/svg+xml", "\n<!--"},
+		{"svg", "image/svg+xml", "\n<!--"},
+		{"html", "text/html; charset=utf-8", "<!DOCTYPE html>"},
+	} {
+		response := postAPI(handler, "/v1/render?format="+test.format, "application/json", testAPIPlan)
+		if response.Code != http.StatusOK {
+			t.Fatalf("format %q: expected 200, got %d: %s", test.format, response.Code, response.Body)
+		}
+		if contentType := response.Header().Get("Content-Type"); contentType != test.contentType {
+			t.Errorf("format %q: expected the content type %s, got %s", test.format, test.contentType, contentType)
+		}
+		body := response.Body.String()
+		if !strings.HasPrefix(body, test.start) || !strings.Contains(body, "<svg") || !strings.Contains(body, "MaxSpeed 100") {
+			t.Errorf("format %q: expected the label, got %.200s", test.format, body)
+		}
+	}
+
+	// pdf is named as not rendered, other formats are unknown
+	if response := postAPI(handler, "/v1/render?format=pdf", "application/json", testAPIPlan); response.Code != http.StatusNotImplemented || !strings.Contains(response.Body.String(), "pdf labels aren't rendered") {
+		t.Errorf("expected 501 for the pdf format, got %d: %s", response.Code, response.Body)
+	}
+	if response := postAPI(handler, "/v1/render?format=png", "application/json", testAPIPlan); response.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 for the png format, got %d: %s", response.Code, response.Body)
+	}
+	if response := postAPI(handler, "/v1/render", "application/json", "["+testAPIPlan+", "+testAPIPlan+"]"); response.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 for two plans, got %d: %s", response.Code, response.Body)
+	}
+}
+
+func TestAPIBatch(t *testing.T) {
+	useTestLayout(t)
+	previousZipName, previousFilter := zipName, planFilter
+	defer func() { zipName, planFilter = previousZipName, previousFilter }()
+	zipName = "generated-labels"
+	// the filters of other commands don't apply to the api
+	planFilter = rowFilter{companies: []string{"Another Company"}}
+
+	body := "[" + testAPIPlan + ", " + strings.Replace(testAPIPlan, `"100"`, `"200"`, 1) + "]"
+	response := postAPI(testAPIHandler(1<<20), "/v1/batch", "application/json", body)
+	if response.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", response.Code, response.Body)
+	}
+	if contentType := response.Header().Get("Content-Type"); contentType != "application/zip" {
+		t.Errorf("expected a zip file, got %s", contentType)
+	}
+
+	archive, err := zip.NewReader(bytes.NewReader(response.Body.Bytes()), int64(response.Body.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	var manifest labelManifest
+	for _, file := range archive.File {
+		names = append(names, file.Name)
+		if file.Name != manifestFileName {
+			continue
+		}
+		reader, err := file.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = json.NewDecoder(reader).Decode(&manifest)
+		reader.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	expected := []string{"F12345000000000000100.svg", "F12345000000000000200.svg", manifestFileName}
+	if strings.Join(names, ",") != strings.Join(expected, ",") {
+		t.Errorf("zip contains %v, expected %v", names, expected)
+	}
+	checksum := sha256.Sum256([]byte(body))
+	if manifest.InputFile != "request.json" || manifest.InputSHA256 != hex.EncodeToString(checksum[:]) || len(manifest.Files) != 2 {
+		t.Errorf("expected the manifest of the request body and its 2 labels, got %+v", manifest)
+	}
+}
+
+func TestAPIInvalidPlan(t *testing.T) {
+	handler := testAPIHandler(1 << 20)
+	plan := strings.Replace(testAPIPlan, "70.00", `"7x"`, 1)
+	for _, endpoint := range []string{"/v1/render", "/v1/batch"} {
+		response := postAPI(handler, endpoint, "application/json", plan)
+		var answer jsonError
+		if err := json.Unmarshal(response.Body.Bytes(), &answer); err != nil || answer.IsError != "true" || answer.Row != "2" {
+			t.Errorf("%s: expected a json error for row 2, got %s", endpoint, response.Body)
+		}
+		if response.Code != http.StatusUnprocessableEntity {
+			t.Errorf("%s: expected 422 for an invalid plan, got %d", endpoint, response.Code)
+		}
+	}
+}
+
+func TestAPIRenderError(t *testing.T) {
+	useTestLayout(t)
+	previousURL, previousEnabled := qrBaseURL, qrEnabled
+	defer func() { qrBaseURL, qrEnabled = previousURL, previousEnabled }()
+	qrBaseURL = "https://labels.example.com/" + strings.Repeat("a", 300)
+	qrEnabled = true
+
+	handler := testAPIHandler(1 << 20)
+	plan := `{"company_name": "Sonar Software", "fcc_id": "12345", "data_service_id": "100", "data_service_price": 70.00, "billing_frequency_in_months": 1, "dl_speed_in_kbps": 100000, "ul_speed_in_kbps": 20000}`
+	for _, endpoint := range []string{"/v1/render", "/v1/batch"} {
+		response := httptest.NewRecorder()
+		request := httptest.NewRequest(http.MethodPost, endpoint, strings.NewReader(plan))
+		request.Header.Set("Content-Type", "application/json")
+		handler.ServeHTTP(response, request)
+
+		var answer jsonError
+		if err := json.Unmarshal(response.Body.Bytes(), &answer); err != nil || answer.Row != "2" {
+			t.Errorf("%s: expected a json error for row 2, got %s", endpoint, response.Body)
+		}
+		if response.Code != http.StatusUnprocessableEntity {
+			t.Errorf("%s: expected 422 for a plan whose qr code can't be drawn, got %d", endpoint, response.Code)
+		}
+	}
+}
+
+func TestServeIntroductoryRateWithoutContract(t *testing.T) {
+	useTestLayout(t)
+	previous := csvFileName
+	defer func() { csvFileName = previous }()
+
+	// an introductory rate on a month to month plan, with no contract_duration
+	csvFileName = filepath.Join(t.TempDir(), "bcd.csv")
+	writeTestFile(t, csvFileName, "company_name,fcc_id,data_service_id,data_service_name,data_service_price,billing_frequency_in_months,introductory_period_in_months,introductory_price_per_month,contract_duration,dl_speed_in_kbps,ul_speed_in_kbps,latency_in_ms\n"+
+		"Sonar Software,12345,100,MaxSpeed 100,70.00,1,6,40.00,,100000,20000,25\n")
+
+	server := &previewServer{reloaded: make(chan struct{})}
+	get := func(path string) *httptest.ResponseRecorder {
+		response := httptest.NewRecorder()
+		server.handler().ServeHTTP(response, httptest.NewRequest(http.MethodGet, path, nil))
+		return response
+	}
+
+	if index := get("/"); !strings.Contains(index.Body.String(), "1 of 1 rows are valid") {
+		t.Errorf("expected the row to be valid, got %s", index.Body)
+	}
+	label := get("/labels/F12345000000000000100.svg")
+	if label.Code != http.StatusOK || !strings.Contains(label.Body.String(), "does not require a contract") {
+		t.Errorf("expected the label, got %d: %.200s", label.Code, label.Body)
+	}
+
+	// a label that can't be drawn is answered with its error
+	previousURL, previousEnabled := qrBaseURL, qrEnabled
+	defer func() { qrBaseURL, qrEnabled = previousURL, previousEnabled }()
+	qrBaseURL, qrEnabled = "https://labels.example.com/"+strings.Repeat("a", 300), true
+	if label := get("/labels/F12345000000000000100.svg"); label.Code == http.StatusOK || !strings.Contains(label.Body.String(), "qr code") {
+		t.Errorf("expected the qr code error, got %d: %.200s", label.Code, label.Body)
+	}
+}
+
+func TestPublishSiteWithFilter(t *testing.T) {
+	useTestLayout(t)
+	previousFilter, previousPrune := planFilter, pruneStale
+	defer func() { planFilter, pruneStale = previousFilter, previousPrune }()
+	siteDirectory := filepath.Join(t.TempDir(), "site")
+	baseURL := "https://labels.example.com/bcd"
+
+	publish := func(filter rowFilter, prune bool) []sitePlan {
+		t.Helper()
+		planFilter, pruneStale = filter, prune
+		plans, err := loadSitePlans("bcd.csv", baseURL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := os.MkdirAll(siteDirectory, 0755); err != nil {
+			t.Fatal(err)
+		}
+		stage, err := newOutputStage(siteDirectory)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := publishSite(stage, siteDirectory, baseURL, plans, defaultSourceDate); err != nil {
+			t.Fatalf("publishSite returned an error: %v", err)
+		}
+		return plans
+	}
+
+	// a plan left out without a page yet gets one
+	plans := publish(rowFilter{rows: []rowRange{{2, 2}}}, false)
+	for _, plan := range plans {
+		if _, err := os.Stat(filepath.Join(siteDirectory, plan.PlanID, sitePlanPageName)); err != nil {
+			t.Errorf("expected a page for the plan of csv row %d: %v", plan.CsvRow, err)
+		}
+	}
+
+	// the page of a plan left out is kept as it is, even with -prune
+	leftOut := filepath.Join(siteDirectory, plans[1].PlanID, sitePlanPageName)
+	writeTestFile(t, leftOut, "earlier page")
+	plans = publish(rowFilter{rows: []rowRange{{2, 2}}}, true)
+	if contents, err := os.ReadFile(leftOut); err != nil || string(contents) != "earlier page" {
+		t.Errorf("expected the page of csv row %d to be kept, got %q (%v)", plans[1].CsvRow, contents, err)
+	}
+
+	// the index, data files and sitemap list every plan
+	for _, fileName := range []string{siteIndexFileName, sitePlansCSVName, sitePlansJSONName, siteSitemapFileName} {
+		contents, err := os.ReadFile(filepath.Join(siteDirectory, fileName))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, plan := range plans {
+			if !strings.Contains(string(contents), plan.PlanID) {
+				t.Errorf("expected %s to list the plan of csv row %d", fileName, plan.CsvRow)
+			}
+		}
+	}
+}
+
+func TestPublishPlanIdentifierTraversal(t *testing.T) {
+	useTestLayout(t)
+	data := testPlanRow()
+	data["fcc_id"] = "/../../escaped"
+	if _, err := newBroadbandData(data); err == nil || !strings.Contains(err.Error(), "fcc_id must only hold letters and digits") {
+		t.Errorf("expected the fcc_id to be refused, got %v", err)
+	}
+	data = testPlanRow()
+	data["data_service_id"] = "..\\100"
+	if _, err := newBroadbandData(data); err == nil {
+		t.Error("expected the data_service_id to be refused")
+	}
+
+	// a page is never written outside of the stage
+	stage := filepath.Join(t.TempDir(), "stage")
+	for _, fileName := range []string{"../escaped/index.html", "/escaped/index.html", "F/../../escaped.svg"} {
+		err := writeSiteFile(stage, fileName, func(w io.Writer) error { return nil })
+		if err == nil {
+			t.Errorf("expected %s to be refused", fileName)
+		}
+	}
+	if entries, _ := os.ReadDir(filepath.Dir(stage)); len(entries) != 0 {
+		t.Errorf("expected nothing to be written, found %d entries", len(entries))
+	}
+}
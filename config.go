@@ -0,0 +1,245 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// defaultConfigFileNames are looked for in the working directory when -config
+// isn't set.
+var defaultConfigFileNames = []string{"sonarbcd.yaml", "sonarbcd.yml", "sonarbcd.toml"}
+
+// configEnvPrefix is prepended to the upper cased flag name to give the
+// environment variable of a setting, eg: SONARBCD_INPUTCSV.
+const configEnvPrefix = "SONARBCD_"
+
+var configFileName string
+
+// configFileInUse is the config file read by the last applyConfig, empty when
+// there was none.
+var configFileInUse string
+
+// sonarbcdConfig is the contents of a config file. The settings are named
+// after the flags they provide a value for, a nil setting leaves the flag
+// alone. Settings with a path tag are relative to the config file.
+//
+// There is no output format or language setting: labels are only written as
+// svg, and their language is that of the text templates, set with
+// templatedir.
+type sonarbcdConfig struct {
+	InputCSV    *string `yaml:"inputcsv" toml:"inputcsv" path:"true"`
+	OutputDir   *string `yaml:"outputdir" toml:"outputdir" path:"true"`
+	ZipName     *string `yaml:"zipname" toml:"zipname"`
+	FileName    *string `yaml:"filename" toml:"filename"`
+	GroupBy     *string `yaml:"groupby" toml:"groupby"`
+	Theme       *string `yaml:"theme" toml:"theme" path:"true"`
+	Layout      *string `yaml:"layout" toml:"layout"`
+	Columns     *int    `yaml:"columns" toml:"columns"`
+	TemplateDir *string `yaml:"templatedir" toml:"templatedir" path:"true"`
+	QRBaseURL   *string `yaml:"qrbaseurl" toml:"qrbaseurl"`
+	QRCode      *bool   `yaml:"qrcode" toml:"qrcode"`
+	Prune       *bool   `yaml:"prune" toml:"prune"`
+	Jobs        *int    `yaml:"jobs" toml:"jobs"`
+	LogFormat   *string `yaml:"logformat" toml:"logformat"`
+	Verbosity   *int    `yaml:"verbosity" toml:"verbosity"`
+
+	// ColumnAliases maps a csv column name to the name sonarbcd expects, for
+	// csv files exported with other headers.
+	ColumnAliases map[string]string `yaml:"column_aliases" toml:"column_aliases"`
+	Rules         validationRules   `yaml:"rules" toml:"rules"`
+}
+
+// configFlagNames lists the flags that can be set by the config file and the
+// environment.
+func configFlagNames() []string {
+	var names []string
+	configType := reflect.TypeOf(sonarbcdConfig{})
+	for i := 0; i < configType.NumField(); i++ {
+		if configType.Field(i).Type.Kind() == reflect.Ptr {
+			names = append(names, configType.Field(i).Tag.Get("yaml"))
+		}
+	}
+	return names
+}
+
+// flagValues returns the flag values of the settings in the config file.
+func (c sonarbcdConfig) flagValues(configDir string) map[string]string {
+	values := make(map[string]string)
+	configValue := reflect.ValueOf(c)
+	for i := 0; i < configValue.NumField(); i++ {
+		field := configValue.Type().Field(i)
+		if field.Type.Kind() != reflect.Ptr || configValue.Field(i).IsNil() {
+			continue
+		}
+		value := fmt.Sprint(configValue.Field(i).Elem().Interface())
+		if field.Tag.Get("path") == "true" && value != "" && !filepath.IsAbs(value) {
+			value = filepath.Join(configDir, value)
+		}
+		values[field.Tag.Get("yaml")] = value
+	}
+	return values
+}
+
+// loadConfig reads a yaml or toml config file, chosen by its extension.
+// Unknown settings are reported, so a misspelt setting isn't silently ignored.
+func loadConfig(fileName string) (sonarbcdConfig, error) {
+	var config sonarbcdConfig
+	contents, err := os.ReadFile(fileName)
+	if err != nil {
+		return config, err
+	}
+
+	switch strings.ToLower(filepath.Ext(fileName)) {
+	case ".yaml", ".yml":
+		decoder := yaml.NewDecoder(bytes.NewReader(contents))
+		decoder.KnownFields(true)
+		if err := decoder.Decode(&config); err != nil && !errors.Is(err, io.EOF) {
+			return config, fmt.Errorf("config file %s: %v", fileName, err)
+		}
+	case ".toml":
+		metadata, err := toml.Decode(string(contents), &config)
+		if err != nil {
+			return config, fmt.Errorf("config file %s: %v", fileName, err)
+		}
+		if undecoded := metadata.Undecoded(); len(undecoded) > 0 {
+			return config, fmt.Errorf("config file %s: unknown setting %s", fileName, undecoded[0])
+		}
+	default:
+		return config, fmt.Errorf("config file %s must be a .yaml, .yml or .toml file", fileName)
+	}
+	return config, nil
+}
+
+// findConfigFile returns the config file given with -config or
+// SONARBCD_CONFIG, or else the one in the working directory. It returns an
+// empty name when there is none.
+func findConfigFile(flags *flag.FlagSet) (string, error) {
+	if isFlagSet(flags, "config") {
+		return configFileName, nil
+	}
+	if fileName, ok := os.LookupEnv(configEnvPrefix + "CONFIG"); ok {
+		return fileName, nil
+	}
+
+	var found []string
+	for _, fileName := range defaultConfigFileNames {
+		if _, err := os.Stat(fileName); err == nil {
+			found = append(found, fileName)
+		}
+	}
+	if len(found) > 1 {
+		return "", fmt.Errorf("found config files %s, keep one or choose one with -config", strings.Join(found, " and "))
+	}
+	if len(found) == 1 {
+		return found[0], nil
+	}
+	return "", nil
+}
+
+// applyConfig sets the flags that weren't given on the command line from
+// their environment variable, or else the config file. Flags given on the
+// command line win over both.
+func applyConfig(flags *flag.FlagSet) error {
+	fileName, err := findConfigFile(flags)
+	if err != nil {
+		return err
+	}
+
+	configFileInUse = fileName
+
+	var config sonarbcdConfig
+	var fileValues map[string]string
+	if fileName != "" {
+		config, err = loadConfig(fileName)
+		if err != nil {
+			return err
+		}
+		fileValues = config.flagValues(filepath.Dir(fileName))
+	}
+
+	for _, name := range configFlagNames() {
+		if flags.Lookup(name) == nil || isFlagSet(flags, name) {
+			continue
+		}
+
+		source := fileName
+		value, ok := os.LookupEnv(configEnvPrefix + strings.ToUpper(name))
+		if ok {
+			source = configEnvPrefix + strings.ToUpper(name)
+		} else if value, ok = fileValues[name]; !ok {
+			continue
+		}
+		if err := flags.Set(name, value); err != nil {
+			return fmt.Errorf("%s: invalid value %q for %s: %v", source, value, name, err)
+		}
+	}
+
+	columnAliases = config.ColumnAliases
+	rules = defaultValidationRules
+	if err := rules.override(config.Rules); err != nil {
+		return fmt.Errorf("config file %s: %v", fileName, err)
+	}
+	return nil
+}
+
+func isFlagSet(flags *flag.FlagSet, name string) bool {
+	set := false
+	flags.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
+	})
+	return set
+}
+
+// validationRules are the limits of the csv checks that can be changed in the
+// config file.
+type validationRules struct {
+	MaxFieldLength       int `yaml:"max_field_length" toml:"max_field_length"`
+	MaxURLLength         int `yaml:"max_url_length" toml:"max_url_length"`
+	MaxCompanyNameLength int `yaml:"max_company_name_length" toml:"max_company_name_length"`
+}
+
+var defaultValidationRules = validationRules{
+	MaxFieldLength:       36,
+	MaxURLLength:         256,
+	MaxCompanyNameLength: 32,
+}
+
+var rules = defaultValidationRules
+
+// override replaces the rules set in overrides, a zero rule is left alone.
+func (r *validationRules) override(overrides validationRules) error {
+	limits := []struct {
+		name     string
+		rule     *int
+		override int
+	}{
+		{"max_field_length", &r.MaxFieldLength, overrides.MaxFieldLength},
+		{"max_url_length", &r.MaxURLLength, overrides.MaxURLLength},
+		{"max_company_name_length", &r.MaxCompanyNameLength, overrides.MaxCompanyNameLength},
+	}
+	for _, limit := range limits {
+		if limit.override < 0 {
+			return fmt.Errorf("rule %s must be positive, got %d", limit.name, limit.override)
+		}
+		if limit.override > 0 {
+			*limit.rule = limit.override
+		}
+	}
+	return nil
+}
+
+// columnAliases maps the csv column names of the config file to the ones
+// sonarbcd expects.
+var columnAliases map[string]string
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadConfig(t *testing.T) {
+	dir := t.TempDir()
+	yamlFile := filepath.Join(dir, "sonarbcd.yaml")
+	writeTestFile(t, yamlFile, "inputcsv: plans.csv\nqrcode: false\njobs: 4\ncolumn_aliases:\n  Provider: company_name\nrules:\n  max_field_length: 40\n")
+	tomlFile := filepath.Join(dir, "sonarbcd.toml")
+	writeTestFile(t, tomlFile, "inputcsv = \"plans.csv\"\nqrcode = false\njobs = 4\n[column_aliases]\nProvider = \"company_name\"\n[rules]\nmax_field_length = 40\n")
+
+	for _, fileName := range []string{yamlFile, tomlFile} {
+		config, err := loadConfig(fileName)
+		if err != nil {
+			t.Fatalf("loadConfig(%s) returned an error: %v", fileName, err)
+		}
+		values := config.flagValues(dir)
+		expected := map[string]string{"inputcsv": filepath.Join(dir, "plans.csv"), "qrcode": "false", "jobs": "4"}
+		if len(values) != len(expected) {
+			t.Errorf("%s gave the flag values %v, expected %v", fileName, values, expected)
+		}
+		for name, value := range expected {
+			if values[name] != value {
+				t.Errorf("%s gave %s the value %q, expected %q", fileName, name, values[name], value)
+			}
+		}
+		if config.ColumnAliases["Provider"] != "company_name" || config.Rules.MaxFieldLength != 40 {
+			t.Errorf("%s gave the aliases %v and rules %+v", fileName, config.ColumnAliases, config.Rules)
+		}
+	}
+
+	// a misspelt setting is an error rather than being ignored
+	writeTestFile(t, yamlFile, "inputcvs: plans.csv\n")
+	writeTestFile(t, tomlFile, "inputcvs = \"plans.csv\"\n")
+	for _, fileName := range []string{yamlFile, tomlFile} {
+		if _, err := loadConfig(fileName); err == nil {
+			t.Errorf("loadConfig(%s) accepted an unknown setting", fileName)
+		}
+	}
+}
+
+func TestApplyConfigPrecedence(t *testing.T) {
+	savedCSV, savedOutput, savedZip, savedJobs := csvFileName, outputDirectory, zipName, labelJobs
+	defer func() {
+		csvFileName, outputDirectory, zipName, labelJobs = savedCSV, savedOutput, savedZip, savedJobs
+		columnAliases = nil
+		rules = defaultValidationRules
+	}()
+
+	configFile := filepath.Join(t.TempDir(), "sonarbcd.yaml")
+	writeTestFile(t, configFile, "inputcsv: /config.csv\noutputdir: /config\nzipname: config\n")
+	t.Setenv("SONARBCD_OUTPUTDIR", "/env")
+	t.Setenv("SONARBCD_ZIPNAME", "env")
+
+	flags := newCommandFlags("test", "", "")
+	addInputFlags(flags)
+	addOutputFlags(flags)
+	if err := parseCommandFlags(flags, []string{"-config", configFile, "-zipname", "flag"}); err != nil {
+		t.Fatal(err)
+	}
+	if csvFileName != "/config.csv" || outputDirectory != "/env" || zipName != "flag" {
+		t.Errorf("got inputcsv %q, outputdir %q and zipname %q, expected the config file, env var and flag values", csvFileName, outputDirectory, zipName)
+	}
+}
@@ -2,21 +2,108 @@ package main
 
 import (
 	"encoding/csv"
+	"errors"
+	"fmt"
 	"os"
+	"strconv"
+	"strings"
 )
 
-func loadCSV(csvFile string) ([][]string, error) {
+// csvRowReader reads a csv file one row at a time, so the whole file is never
+// held in memory.
+type csvRowReader struct {
+	file   *os.File
+	reader *csv.Reader
+	header []string
+	row    int
+	// rows the filter leaves out are skipped
+	filter rowFilter
+	// skipComment is called with the row number of each comment row skipped,
+	// it logs a warning unless it is replaced
+	skipComment func(row int)
+}
+
+// openCSV opens a csv file with the rows selected by planFilter.
+func openCSV(csvFile string) (*csvRowReader, error) {
+	return openFilteredCSV(csvFile, planFilter)
+}
+
+// openFilteredCSV opens a csv file with the rows selected by filter.
+func openFilteredCSV(csvFile string, filter rowFilter) (*csvRowReader, error) {
 	file, err := os.Open(csvFile)
 	if err != nil {
 		return nil, err
 	}
-	defer file.Close()
 
 	reader := csv.NewReader(file)
+	reader.ReuseRecord = true
 
-	records, err := reader.ReadAll()
+	header, err := reader.Read()
 	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	r := &csvRowReader{
+		file:   file,
+		reader: reader,
+		header: append([]string(nil), header...),
+		row:    1,
+		filter: filter,
+		skipComment: func(row int) {
+			logWarning("%s", commentRowWarning(row))
+		},
+	}
+	for i, name := range r.header {
+		if alias, ok := columnAliases[name]; ok {
+			r.header[i] = alias
+		}
+	}
+	if err := r.filter.checkColumns(r.header); err != nil {
+		file.Close()
 		return nil, err
 	}
-	return records, nil
+	return r, nil
+}
+
+// next returns the next row as a map of the header names to the values, with
+// the row number under "csvrow". Rows starting with csvCommentPrefix are
+// skipped and passed to skipComment, rows left out by the filter are skipped.
+// It returns io.EOF after the last row.
+func (r *csvRowReader) next() (map[string]string, error) {
+	for {
+		record, err := r.reader.Read()
+		if err != nil {
+			var parseError *csv.ParseError
+			if errors.As(err, &parseError) {
+				return nil, convertErrorToJSON(strconv.Itoa(parseError.StartLine), "CSV:", parseError.Err.Error())
+			}
+			return nil, err
+		}
+		r.row++
+		if strings.HasPrefix(record[0], csvCommentPrefix) {
+			r.skipComment(r.row)
+			continue
+		}
+
+		data := make(map[string]string, len(record)+1)
+		for i, value := range record {
+			data[r.header[i]] = value
+		}
+		if !r.filter.matches(data, r.row) {
+			continue
+		}
+		data["csvrow"] = strconv.Itoa(r.row)
+		return data, nil
+	}
+}
+
+// commentRowWarning tells that a row was skipped as a comment, as a company
+// name starting with csvCommentPrefix would be.
+func commentRowWarning(row int) string {
+	return fmt.Sprintf("CSV row %d is skipped as a comment as its first cell starts with %s", row, csvCommentPrefix)
+}
+
+func (r *csvRowReader) Close() error {
+	return r.file.Close()
 }
@@ -7,58 +7,50 @@ import (
 	"strings"
 )
 
-func checkCsvRecords() error {
-	records, err := loadCSV(csvFileName)
+// checkCsvRecord validates one row of the csv file, as read by csvRowReader.
+func checkCsvRecord(data map[string]string) error {
+	err := validateFieldLengths(data)
 	if err != nil {
 		return err
 	}
-	header := records[0]
 
-	for recordNumber, record := range records[1:] {
-		data := make(map[string]string)
-		for i, value := range record {
-			data[header[i]] = value
-		}
-		data["csvrow"] = strconv.Itoa(recordNumber + 2)
-
-		err := validateFieldLengths(data)
-		if err != nil {
-			return err
-		}
+	err = validatePlanIdentifiers(data)
+	if err != nil {
+		return err
+	}
 
-		err = validateIntroductoryFields(data)
-		if err != nil {
-			return err
-		}
+	err = validateIntroductoryFields(data)
+	if err != nil {
+		return err
+	}
 
-		err = validateDataServicePrice(data)
-		if err != nil {
-			return err
-		}
+	err = validatePriceSchedule(data)
+	if err != nil {
+		return err
+	}
 
-		err = validateSpeeds(data)
-		if err != nil {
-			return err
-		}
+	err = validateDataServicePrice(data)
+	if err != nil {
+		return err
 	}
 
-	return nil
+	return validateSpeeds(data)
 }
 
 func validateFieldLengths(data map[string]string) error {
 	for key, value := range data {
-		if strings.Contains(key, "_url") && len(value) > 256 {
-			return convertErrorToJSON(data["csvrow"], "CSV: ", key, " must be less than 256 characters in length")
+		if strings.Contains(key, "_url") && len(value) > rules.MaxURLLength {
+			return convertErrorToJSON(data["csvrow"], "CSV: ", key, " must be less than "+strconv.Itoa(rules.MaxURLLength)+" characters in length")
 		}
 
-		if !strings.Contains(key, "_url") && key != "company_name" && len(value) > 36 {
-			return convertErrorToJSON(data["csvrow"], "CSV: ", key, " must be less than 36 characters in length")
+		if !strings.Contains(key, "_url") && key != "company_name" && len(value) > rules.MaxFieldLength {
+			return convertErrorToJSON(data["csvrow"], "CSV: ", key, " must be less than "+strconv.Itoa(rules.MaxFieldLength)+" characters in length")
 		}
 
 	}
 
-	if len(data["company_name"]) > 32 {
-		return convertErrorToJSON(data["csvrow"], "CSV: company_name must be less than 32 characters in length")
+	if len(data["company_name"]) > rules.MaxCompanyNameLength {
+		return convertErrorToJSON(data["csvrow"], "CSV: company_name must be less than "+strconv.Itoa(rules.MaxCompanyNameLength)+" characters in length")
 	}
 	return nil
 }
@@ -91,6 +83,43 @@ func validateIntroductoryFields(data map[string]string) error {
 	return nil
 }
 
+func validatePriceSchedule(data map[string]string) error {
+	schedule, err := buildPriceSchedule(data)
+	if err != nil {
+		return convertErrorToJSON(data["csvrow"], "CSV:", err.Error())
+	}
+
+	if len(schedule) == 0 {
+		return nil
+	}
+
+	// the price of the single introductory_* columns is covered by
+	// validateIntroductoryFields
+	if data["introductory_period_in_months"] == "" {
+		for _, step := range schedule {
+			price := step.PricePerMonth
+			if match, _ := regexp.MatchString(`^\d{1,3}(\.\d{1,2})?$`, price); !match {
+				return convertErrorToJSON(data["csvrow"], "CSV: Promo price format should be [$]###.##, step", strconv.Itoa(step.StepNumber), "csv value:", price)
+			}
+		}
+	}
+
+	// an empty or 0 contract_duration is a plan without a contract, which
+	// puts no limit on the length of the promotion
+	contractDuration, hasContract, err := parseContractDuration(data["contract_duration"])
+	if err != nil {
+		return convertErrorToJSON(data["csvrow"], "CSV: contract_duration must be a whole number of months, or empty or 0 for no contract, csv value:", data["contract_duration"])
+	}
+	if !hasContract {
+		return nil
+	}
+
+	if scheduleLength := priceScheduleLength(schedule); scheduleLength > contractDuration {
+		return convertErrorToJSON(data["csvrow"], "CSV: the introductory period or promo steps cover", strconv.Itoa(scheduleLength), "months which is longer than the", strconv.Itoa(contractDuration), "month contract")
+	}
+	return nil
+}
+
 func validateDataServicePrice(data map[string]string) error {
 	dataServicePrice, exists := data["data_service_price"]
 
@@ -111,6 +140,19 @@ func validateDataServicePrice(data map[string]string) error {
 	return nil
 }
 
+var planIdentifierPattern = regexp.MustCompile(`^[A-Za-z0-9]*$`)
+
+// validatePlanIdentifiers checks the fields of the unique plan identifier,
+// which also names files and the directories of published pages.
+func validatePlanIdentifiers(data map[string]string) error {
+	for _, key := range []string{"fcc_id", "data_service_id"} {
+		if !planIdentifierPattern.MatchString(data[key]) {
+			return convertErrorToJSON(data["csvrow"], "CSV: "+key+" must only hold letters and digits, csv value:", data[key])
+		}
+	}
+	return nil
+}
+
 func validateSpeeds(data map[string]string) error {
 	dlSpeed, dlExists := data["dl_speed_in_kbps"]
 	ulSpeed, ulExists := data["ul_speed_in_kbps"]
@@ -0,0 +1,337 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+const (
+	diffFormatText = "text"
+	diffFormatJSON = "json"
+	diffFormatHTML = "html"
+	// diffFormatLabels renders the labels of the changed plans side by side
+	diffFormatLabels = "labels"
+)
+
+func diffCommand(args []string) error {
+	var format, outputFile string
+
+	flags := newCommandFlags("diff", "[options] old.csv new.csv", "Compares the plans of two csv files by unique plan identifier and lists the plans added, removed and changed, with the before and after value of each field shown on the label.")
+	addRenderFlags(flags)
+	flags.StringVar(&format, "format", diffFormatText, "the format of the report: text, json, html, or labels for an html page of the old and new labels side by side")
+	flags.StringVar(&outputFile, "o", "-", "the file to write the report to, - for stdout")
+	if err := parseCommandFlags(flags, args); err != nil {
+		return err
+	}
+	if flags.NArg() != 2 {
+		flags.Usage()
+		return fmt.Errorf("diff needs the old and the new csv file")
+	}
+
+	writeReport, ok := map[string]func(io.Writer, planDiff) error{
+		diffFormatText:   writePlanDiffText,
+		diffFormatJSON:   writePlanDiffJSON,
+		diffFormatHTML:   writePlanDiffHTML,
+		diffFormatLabels: writePlanDiffLabels,
+	}[format]
+	if !ok {
+		return fmt.Errorf("unknown format %q, expected %s, %s, %s or %s", format, diffFormatText, diffFormatJSON, diffFormatHTML, diffFormatLabels)
+	}
+	if format == diffFormatLabels {
+		if err := setupRendering(); err != nil {
+			return err
+		}
+	}
+
+	oldPlans, err := loadPlans(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	newPlans, err := loadPlans(flags.Arg(1))
+	if err != nil {
+		return err
+	}
+
+	diff := planDiff{
+		OldFile: flags.Arg(0),
+		NewFile: flags.Arg(1),
+		Changes: diffPlans(oldPlans, newPlans),
+	}
+	if err := writeOutputFile(outputFile, func(w io.Writer) error { return writeReport(w, diff) }); err != nil {
+		return err
+	}
+
+	added, removed, changed := diff.counts()
+	logInfo("plans: %d added, %d removed, %d changed", added, removed, changed)
+	return nil
+}
+
+// writeOutputFile writes to stdout when fileName is -, or else to the file.
+func writeOutputFile(fileName string, write func(w io.Writer) error) error {
+	if fileName == "-" {
+		return write(os.Stdout)
+	}
+
+	file, err := os.Create(fileName)
+	if err != nil {
+		return err
+	}
+	if err := write(file); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// loadPlans reads every plan of a csv file, for the commands that compare
+// whole files, the row filters don't apply.
+func loadPlans(csvFile string) ([]BroadbandData, error) {
+	reader, err := openFilteredCSV(csvFile, rowFilter{})
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	var plans []BroadbandData
+	for {
+		data, err := reader.next()
+		if errors.Is(err, io.EOF) {
+			return plans, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		plan, err := newBroadbandData(data)
+		if err != nil {
+			return nil, err
+		}
+		plans = append(plans, plan)
+	}
+}
+
+const (
+	planAdded   = "added"
+	planRemoved = "removed"
+	planChanged = "changed"
+)
+
+type planDiff struct {
+	OldFile string       `json:"old_file"`
+	NewFile string       `json:"new_file"`
+	Changes []planChange `json:"changes"`
+}
+
+func (d planDiff) counts() (added, removed, changed int) {
+	for _, change := range d.Changes {
+		switch change.Change {
+		case planAdded:
+			added++
+		case planRemoved:
+			removed++
+		default:
+			changed++
+		}
+	}
+	return added, removed, changed
+}
+
+// planChange is a plan that differs between the csv files. Added and removed
+// plans list each of their fields that is set, against an empty value.
+type planChange struct {
+	PlanID          string        `json:"unique_plan_identifier"`
+	Change          string        `json:"change"`
+	CompanyName     string        `json:"company_name"`
+	DataServiceName string        `json:"data_service_name"`
+	OldCsvRow       int           `json:"old_csv_row,omitempty"`
+	NewCsvRow       int           `json:"new_csv_row,omitempty"`
+	Fields          []fieldChange `json:"fields"`
+
+	// oldPlan and newPlan are nil for added and removed plans respectively
+	oldPlan, newPlan *BroadbandData
+}
+
+type fieldChange struct {
+	Field string `json:"field"`
+	Old   string `json:"old"`
+	New   string `json:"new"`
+}
+
+// diffPlans matches the plans by unique plan identifier. Changed and added
+// plans are listed in the order of the new csv file, followed by the removed
+// plans in the order of the old one.
+func diffPlans(oldPlans, newPlans []BroadbandData) []planChange {
+	oldByID := make(map[string]*BroadbandData, len(oldPlans))
+	for i := range oldPlans {
+		oldByID[uniquePlanID(oldPlans[i])] = &oldPlans[i]
+	}
+	newByID := make(map[string]bool, len(newPlans))
+
+	var changes []planChange
+	for i := range newPlans {
+		newPlan := newPlans[i]
+		planID := uniquePlanID(newPlan)
+		newByID[planID] = true
+		change := planChange{
+			PlanID:          planID,
+			CompanyName:     newPlan.CompanyName,
+			DataServiceName: newPlan.DataServiceName,
+			NewCsvRow:       newPlan.CsvRow,
+			newPlan:         &newPlans[i],
+		}
+
+		oldPlan, ok := oldByID[planID]
+		if !ok {
+			change.Change = planAdded
+			change.Fields = diffFields(BroadbandData{}, newPlan)
+			changes = append(changes, change)
+			continue
+		}
+		if fields := diffFields(*oldPlan, newPlan); len(fields) > 0 {
+			change.Change = planChanged
+			change.OldCsvRow = oldPlan.CsvRow
+			change.oldPlan = oldPlan
+			change.Fields = fields
+			changes = append(changes, change)
+		}
+	}
+
+	for i := range oldPlans {
+		oldPlan := oldPlans[i]
+		planID := uniquePlanID(oldPlan)
+		if !newByID[planID] {
+			changes = append(changes, planChange{
+				PlanID:          planID,
+				Change:          planRemoved,
+				CompanyName:     oldPlan.CompanyName,
+				DataServiceName: oldPlan.DataServiceName,
+				OldCsvRow:       oldPlan.CsvRow,
+				Fields:          diffFields(oldPlan, BroadbandData{}),
+				oldPlan:         &oldPlans[i],
+			})
+		}
+	}
+	return changes
+}
+
+// diffFields compares every field of two plans, including the ones worked
+// out from the csv like MonthlyPrice, apart from the csv row.
+func diffFields(oldPlan, newPlan BroadbandData) []fieldChange {
+	var fields []fieldChange
+	oldValue := reflect.ValueOf(oldPlan)
+	newValue := reflect.ValueOf(newPlan)
+	for i := 0; i < oldValue.NumField(); i++ {
+		name := oldValue.Type().Field(i).Name
+		if name == "CsvRow" {
+			continue
+		}
+		before := diffValue(oldValue.Field(i).Interface())
+		after := diffValue(newValue.Field(i).Interface())
+		if before != after {
+			fields = append(fields, fieldChange{Field: name, Old: before, New: after})
+		}
+	}
+	return fields
+}
+
+// diffValue formats a field of a plan the way it reads on the label, a false
+// flag is left empty like an empty csv value.
+func diffValue(value interface{}) string {
+	switch value := value.(type) {
+	case string:
+		return value
+	case bool:
+		if value {
+			return "true"
+		}
+		return ""
+	case []PriceStep:
+		steps := make([]string, len(value))
+		for i, step := range value {
+			steps[i] = "$" + step.PricePerMonth + " for " + strconv.Itoa(step.PeriodInMonths) + " months"
+		}
+		return strings.Join(steps, ", then ")
+	case []AdditionalCharges:
+		charges := make([]string, len(value))
+		for i, charge := range value {
+			charges[i] = charge.ChargeName + " $" + charge.ChargeValue
+		}
+		return strings.Join(charges, ", ")
+	default:
+		return fmt.Sprint(value)
+	}
+}
+
+var diffMarks = map[string]string{planAdded: "+", planRemoved: "-", planChanged: "~"}
+
+func writePlanDiffText(w io.Writer, diff planDiff) error {
+	for _, change := range diff.Changes {
+		if _, err := fmt.Fprintf(w, "%s %s %s %s\n", diffMarks[change.Change], change.PlanID, change.CompanyName, change.DataServiceName); err != nil {
+			return err
+		}
+		// the fields of added and removed plans are only in the json and html
+		// reports, to keep the text short
+		if change.Change != planChanged {
+			continue
+		}
+		for _, field := range change.Fields {
+			if _, err := fmt.Fprintf(w, "    %s: %q -> %q\n", field.Field, field.Old, field.New); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func writePlanDiffJSON(w io.Writer, diff planDiff) error {
+	if diff.Changes == nil {
+		diff.Changes = []planChange{}
+	}
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(diff)
+}
+
+var planDiffTemplate = template.Must(template.New("diff").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>{{.OldFile}} to {{.NewFile}}</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; margin-bottom: 2em; }
+th, td { border: 1px solid #ccc; padding: 0.3em 0.6em; text-align: left; vertical-align: top; }
+.added h2 { color: #1a7f37; }
+.removed h2 { color: #cf222e; }
+.old { background: #ffebe9; }
+.new { background: #dafbe1; }
+</style>
+</head>
+<body>
+<h1>{{.OldFile}} to {{.NewFile}}</h1>
+<p>{{.Added}} added, {{.Removed}} removed, {{.Changed}} changed</p>
+{{range .Changes}}<section class="{{.Change}}">
+<h2>{{.Change}}: {{.CompanyName}} {{.DataServiceName}}</h2>
+<p>{{.PlanID}}{{if .OldCsvRow}}, old csv row {{.OldCsvRow}}{{end}}{{if .NewCsvRow}}, new csv row {{.NewCsvRow}}{{end}}</p>
+<table>
+<tr><th>Field</th><th>Before</th><th>After</th></tr>
+{{range .Fields}}<tr><td>{{.Field}}</td><td class="old">{{.Old}}</td><td class="new">{{.New}}</td></tr>
+{{end}}</table>
+</section>
+{{end}}</body>
+</html>
+`))
+
+func writePlanDiffHTML(w io.Writer, diff planDiff) error {
+	added, removed, changed := diff.counts()
+	return planDiffTemplate.Execute(w, struct {
+		planDiff
+		Added, Removed, Changed int
+	}{diff, added, removed, changed})
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+)
+
+// csvField describes a column of the csv file. The list of fields is used
+// for the template and field reference written by init, the README and the
+// header check, so they all agree on the columns sonarbcd reads.
+type csvField struct {
+	Name string
+	// Formats are the accepted formats, with an example of each.
+	Formats []string
+	Notes   string
+	// Sample is the value of the field in the example row of the template.
+	Sample string
+}
+
+// csvFields are the columns in the order of the template. Numbered columns
+// are named with _N, the template has the first of each.
+var csvFields = []csvField{
+	{Name: "company_name", Formats: []string{`Text, eg: "Sonar Software"`}, Sample: "Sonar Software"},
+	{Name: "discounts_and_bundles_url", Formats: []string{"URL, eg: https://www.sonar.software"}, Sample: "https://www.sonar.software/discounts"},
+	{Name: "acp", Formats: []string{"Boolean (true/false)"}, Notes: `This is the Affordability Connectivity Program, use "Yes" or "No" if this package does or does not apply under ACP respectively.`, Sample: "Yes"},
+	{Name: "customer_support_url", Formats: []string{"URL, eg: https://www.sonar.software"}, Sample: "https://www.sonar.software/support"},
+	{Name: "customer_support_phone", Formats: []string{"Phone Number, eg: 702-447-1247"}, Sample: "702-447-1247"},
+	{Name: "network_management_url", Formats: []string{"URL, eg: https://www.sonar.software"}, Sample: "https://www.sonar.software/network"},
+	{Name: "privacy_policy_url", Formats: []string{"URL, eg: https://www.sonar.software"}, Sample: "https://www.sonar.software/privacy"},
+	{Name: "fcc_id", Formats: []string{"Text, letters and digits only"}, Sample: "12345"},
+	{Name: "data_service_id", Formats: []string{`Text, letters and digits only, eg: "SONAR100"`}, Notes: `"This is your internal data service id, this is combined with fix_or_mobile and the fcc_id to create the unique plan id"`, Sample: "100"},
+	{Name: "data_service_name", Formats: []string{`Text, eg: "MaxSpeed 100"`}, Sample: "MaxSpeed 100"},
+	{Name: "fixed_or_mobile", Formats: []string{`Text, eg: "Fixed" or "Mobile"`}, Sample: "Fixed"},
+	{Name: "data_service_price", Formats: []string{"Price (e.g., $###.###), eg: $70.00"}, Notes: "This is the regular service price after introductory period is done.", Sample: "70.00"},
+	{Name: "billing_frequency_in_months", Formats: []string{"Integer (Number of months), eg: 1"}, Notes: `Prices are always entered per month. The label headline shows the monthly price; when billing is not monthly an extra line shows the amount charged each period at the regular data_service_price, eg: "Amount billed every 3 months $224.85" or "Amount billed annually", or "Regular amount billed every 3 months" for a plan with an introductory price.`, Sample: "1"},
+	{Name: "introductory_period_in_months", Formats: []string{"Integer (Number of months), eg: 6"}},
+	{Name: "introductory_price_per_month", Formats: []string{"Price (e.g., $###.##), eg: $50.00"}},
+	{Name: "promo_period_in_months_N", Formats: []string{"Integer (Number of months), eg: promo_period_in_months_1 = 6"}, Notes: "Optional promotional price schedule, numbered from 1 without gaps, with promo_price_per_month_N. Each step is shown on the label in order, followed by the regular data_service_price. A schedule of \"$40 for 6 months, then $55 for 6 months, then $70\" is entered as two steps with a data_service_price of $70.00. The total of all steps must not exceed contract_duration when there is a contract, and these columns can not be combined with introductory_period_in_months / introductory_price_per_month.", Sample: "6"},
+	{Name: "promo_price_per_month_N", Formats: []string{"Price (e.g., $###.##), eg: promo_price_per_month_1 = $40.00"}, Notes: "The monthly price of promotional step N, see promo_period_in_months_N.", Sample: "40.00"},
+	{Name: "contract_duration", Formats: []string{"Integer (Number of months), eg: 12"}, Notes: "Empty or 0 for a plan without a contract, such as month to month. An introductory period or promo schedule can't be longer than the contract.", Sample: "12"},
+	{Name: "contract_url", Formats: []string{"URL, eg: https://www.sonar.software"}, Sample: "https://www.sonar.software/contract"},
+	{Name: "monthly_fee_name_N", Formats: []string{`Text, eg: monthly_fee_name_1 = "Equipment rental"`}, Notes: "Optional monthly fees, numbered from 1, each with a monthly_fee_price_N column. Names longer than 42 characters are shortened on the label.", Sample: "Equipment rental"},
+	{Name: "monthly_fee_price_N", Formats: []string{"Price (e.g., $###.##), eg: monthly_fee_price_1 = $10.00"}, Notes: "The price of monthly fee N, required when monthly_fee_name_N is set.", Sample: "10.00"},
+	{Name: "one_time_fee_name_N", Formats: []string{`Text, eg: one_time_fee_name_1 = "Installation"`}, Notes: "Optional one-time fees, numbered from 1, each with a one_time_fee_price_N column. Names longer than 42 characters are shortened on the label.", Sample: "Installation"},
+	{Name: "one_time_fee_price_N", Formats: []string{"Price (e.g., $###.##), eg: one_time_fee_price_1 = $100.00"}, Notes: "The price of one-time fee N, required when one_time_fee_name_N is set.", Sample: "100.00"},
+	{Name: "early_termination_fee", Formats: []string{"Price (e.g., $###.###), eg: $100.00"}, Sample: "100.00"},
+	{Name: "dl_speed_in_kbps", Formats: []string{
+		"Integer, eg: 100000, interpreted as Kbps and will be converted to Mbps with 1 place decimal precision (eg: 1.5 Mbps not 1.50 Mbps)",
+		"Decimal, eg: 1.5, interpreted as Mbps and will be converted to 1 place decimal precision.",
+	}, Notes: "Any decimals ending in .0 are converted to whole numbers (eg: 100.0 Mbps is displayed as 100 Mbps)", Sample: "100000"},
+	{Name: "ul_speed_in_kbps", Formats: []string{
+		"Integer, eg: 100000, interpreted as Kbps and will be converted to Mbps with 1 place decimal precision (eg: 1.5 Mbps not 1.50 Mbps)",
+		"Decimal, eg: 1.5, interpreted as Mbps and will be converted to 1 place decimal precision.",
+	}, Notes: "Any decimals ending in .0 are converted to whole numbers (eg: 100.0 Mbps is displayed as 100 Mbps)", Sample: "20000"},
+	{Name: "latency_in_ms", Formats: []string{"Integer (Milliseconds), eg: 120"}, Sample: "25"},
+	{Name: "data_included_in_monthly_price", Formats: []string{"Integer (GB), eg: 1000"}},
+	{Name: "overage_fee", Formats: []string{"Price (e.g., $###.###), eg: $5.00"}},
+	{Name: "overage_data_amount", Formats: []string{"Integer (GB), eg: 5"}},
+}
+
+// csvCommentPrefix starts the first cell of a row that is skipped, like the
+// example row of the template.
+const csvCommentPrefix = "#"
+
+var numberedColumnPattern = regexp.MustCompile(`^(.+_)([1-9][0-9]*)$`)
+
+// lookupCSVField returns the field of a csv column, numbered columns match
+// their _N field.
+func lookupCSVField(column string) (csvField, bool) {
+	name := column
+	if match := numberedColumnPattern.FindStringSubmatch(column); match != nil {
+		name = match[1] + "N"
+	}
+	for _, field := range csvFields {
+		if field.Name == name {
+			return field, true
+		}
+	}
+	return csvField{}, false
+}
+
+// checkCsvHeader returns a warning for each column sonarbcd doesn't read and
+// each numbered fee or promo column missing its partner, eg: a
+// monthly_fee_name_2 without monthly_fee_price_2.
+func checkCsvHeader(header []string) []string {
+	columns := make(map[string]bool, len(header))
+	for _, column := range header {
+		columns[column] = true
+	}
+
+	partners := map[string]string{
+		"monthly_fee_name_":       "monthly_fee_price_",
+		"monthly_fee_price_":      "monthly_fee_name_",
+		"one_time_fee_name_":      "one_time_fee_price_",
+		"one_time_fee_price_":     "one_time_fee_name_",
+		"promo_period_in_months_": "promo_price_per_month_",
+		"promo_price_per_month_":  "promo_period_in_months_",
+	}
+
+	var warnings []string
+	for _, column := range header {
+		if _, ok := lookupCSVField(column); !ok {
+			warnings = append(warnings, fmt.Sprintf("csv column %q isn't a sonarbcd field and is ignored, see the field reference written by sonarbcd init", column))
+			continue
+		}
+		if match := numberedColumnPattern.FindStringSubmatch(column); match != nil {
+			if partner, ok := partners[match[1]]; ok && !columns[partner+match[2]] {
+				warnings = append(warnings, fmt.Sprintf("csv column %q has no %q column", column, partner+match[2]))
+			}
+		}
+	}
+	return warnings
+}
+
+// templateColumns returns the header of the template, with the first column
+// of each numbered field.
+func templateColumns() []string {
+	columns := make([]string, len(csvFields))
+	for i, field := range csvFields {
+		columns[i] = strings.TrimSuffix(field.Name, "_N")
+		if columns[i] != field.Name {
+			columns[i] += "_1"
+		}
+	}
+	return columns
+}
+
+// templateExampleRow returns the example row of the template, commented out
+// so it is skipped until the comment prefix is removed.
+func templateExampleRow() []string {
+	row := make([]string, len(csvFields))
+	for i, field := range csvFields {
+		row[i] = field.Sample
+	}
+	row[0] = csvCommentPrefix + row[0]
+	return row
+}
+
+// writeFieldReference writes the markdown description of every csv field, as
+// found under CSV Field Parameters in the README.
+func writeFieldReference(w io.Writer) error {
+	var reference strings.Builder
+	for i, field := range csvFields {
+		number := fmt.Sprintf("%d. ", i+1)
+		indent := strings.Repeat(" ", len(number))
+		fmt.Fprintf(&reference, "%s**%s:**\n", number, field.Name)
+		for _, format := range field.Formats {
+			fmt.Fprintf(&reference, "%s- Format: %s\n", indent, format)
+		}
+		if field.Notes != "" {
+			fmt.Fprintf(&reference, "%s- Notes: %s\n", indent, field.Notes)
+		}
+		if i < len(csvFields)-1 {
+			reference.WriteString("\n")
+		}
+	}
+	_, err := io.WriteString(w, reference.String())
+	return err
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestREADMEFieldReference keeps the field formats of the README in step with
+// the field definitions, update the README with the output of sonarbcd init
+// when it fails.
+func TestREADMEFieldReference(t *testing.T) {
+	readme, err := os.ReadFile("README.md")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var reference strings.Builder
+	if err := writeFieldReference(&reference); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(readme), reference.String()) {
+		t.Error("the field formats of the README don't match the field definitions")
+	}
+}
+
+func TestTemplateExampleRow(t *testing.T) {
+	columns := templateColumns()
+	example := templateExampleRow()
+	if len(columns) != len(example) {
+		t.Fatalf("the template has %d columns and an example row of %d", len(columns), len(example))
+	}
+	if warnings := checkCsvHeader(columns); len(warnings) > 0 {
+		t.Errorf("the template header has warnings: %v", warnings)
+	}
+
+	// the example row is skipped until it is uncommented, then it is a valid plan
+	var csv strings.Builder
+	csv.WriteString(strings.Join(columns, ",") + "\n")
+	csv.WriteString(strings.Join(example, ",") + "\n")
+	csv.WriteString(strings.TrimPrefix(strings.Join(example, ","), csvCommentPrefix) + "\n")
+	csvFile := filepath.Join(t.TempDir(), "template.csv")
+	writeTestFile(t, csvFile, csv.String())
+
+	plan, err := findPlan(csvFile, "", 3)
+	if err != nil {
+		t.Fatalf("the uncommented example row isn't valid: %v", err)
+	}
+	if plan.CsvRow != 3 || plan.CompanyName != "Sonar Software" {
+		t.Errorf("got csv row %d of %q, expected the comment row to count as row 2", plan.CsvRow, plan.CompanyName)
+	}
+	if _, err := findPlan(csvFile, "", 2); err == nil {
+		t.Error("the commented example row was read as a plan")
+	}
+}
+
+func TestCheckCsvHeader(t *testing.T) {
+	warnings := checkCsvHeader([]string{"company_name", "monthly_fee_name_1", "monthly_fee_1", "promo_period_in_months_2", "promo_price_per_month_2"})
+	if len(warnings) != 2 || !strings.Contains(warnings[0], `"monthly_fee_price_1"`) || !strings.Contains(warnings[1], `"monthly_fee_1"`) {
+		t.Errorf("got the warnings %q, expected the missing monthly_fee_price_1 and unknown monthly_fee_1", warnings)
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"text/template"
+)
+
+const defaultFileNamePattern = "{{.PlanID}}"
+
+// LabelFileNameData is the data available to the -filename pattern. Every
+// string field is already slug sanitized.
+type LabelFileNameData struct {
+	PlanID        string
+	Company       string
+	ServiceName   string
+	DataServiceID string
+	FixedOrMobile string
+	// labels are only generated in English for now
+	Language string
+	Row      int
+	Index    int
+}
+
+var slugInvalidCharacters = regexp.MustCompile(`[^A-Za-z0-9._]+`)
+
+// slugify keeps letters, digits, dots and underscores and replaces every other
+// run of characters with a single dash.
+func slugify(value string) string {
+	slug := slugInvalidCharacters.ReplaceAllString(value, "-")
+	return strings.Trim(slug, "-.")
+}
+
+func parseFileNamePattern(pattern string) (*template.Template, error) {
+	t, err := template.New("filename").Option("missingkey=error").Parse(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("filename pattern: %v", err)
+	}
+	return t, nil
+}
+
+// labelFileNamer works out the file name of each label of a group as the csv
+// is read. It remembers the names already used, so a pattern that maps two
+// plans to the same file fails the run instead of one label silently
+// replacing another.
+type labelFileNamer struct {
+	pattern  string
+	template *template.Template
+	usedBy   map[string]int
+}
+
+func newLabelFileNamer(pattern string) (*labelFileNamer, error) {
+	t, err := parseFileNamePattern(pattern)
+	if err != nil {
+		return nil, err
+	}
+	return &labelFileNamer{pattern: pattern, template: t, usedBy: make(map[string]int)}, nil
+}
+
+// next returns the file name of the next label of the group.
+func (n *labelFileNamer) next(template BroadbandData) (string, error) {
+	data := LabelFileNameData{
+		PlanID:        slugify(uniquePlanID(template)),
+		Company:       slugify(template.CompanyName),
+		ServiceName:   slugify(template.DataServiceName),
+		DataServiceID: slugify(template.DataServiceID),
+		FixedOrMobile: slugify(template.FixedOrMobile),
+		Language:      "en",
+		Row:           template.CsvRow,
+		Index:         len(n.usedBy),
+	}
+
+	var fileName strings.Builder
+	if err := n.template.Execute(&fileName, data); err != nil {
+		return "", fmt.Errorf("filename pattern: %v", err)
+	}
+
+	name := strings.TrimSpace(fileName.String())
+	if name == "" || strings.ContainsAny(name, `/\`) || name != filepath.Base(name) {
+		return "", fmt.Errorf("filename pattern %q gives the invalid file name %q for csv row %d", n.pattern, name, template.CsvRow)
+	}
+	if !strings.HasSuffix(strings.ToLower(name), ".svg") {
+		name += ".svg"
+	}
+
+	// compare case insensitively, some file systems do
+	key := strings.ToLower(name)
+	if row, ok := n.usedBy[key]; ok {
+		return "", fmt.Errorf("filename pattern %q gives csv rows %d and %d the same file name %s", n.pattern, row, template.CsvRow, name)
+	}
+	n.usedBy[key] = template.CsvRow
+	return name, nil
+}
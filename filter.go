@@ -0,0 +1,291 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// planFilter selects the rows of the csv file a command works on, rows it
+// leaves out are skipped by csvRowReader.next as comment rows are.
+var planFilter rowFilter
+
+// rowFilter keeps a row when it matches every option that is set. Within an
+// option a row matches any of the values.
+type rowFilter struct {
+	companies     []string
+	planIDs       []string
+	serviceIDs    []string
+	fixedOrMobile string
+	rows          []rowRange
+	where         []filterExpression
+}
+
+// addFilterFlags adds the options that select the rows to work on.
+func addFilterFlags(flags *flag.FlagSet) {
+	planFilter = rowFilter{}
+	flags.Var((*listFlag)(&planFilter.companies), "company", "only the rows of this company name, can be repeated")
+	flags.Var(&commaListFlag{&planFilter.planIDs}, "planid", "only the rows with these comma separated unique plan identifiers, can be repeated")
+	flags.Var(&commaListFlag{&planFilter.serviceIDs}, "serviceid", "only the rows with these comma separated data service ids, can be repeated")
+	flags.Func("fixedormobile", "only the fixed or the mobile rows", func(value string) error {
+		if !strings.EqualFold(value, "fixed") && !strings.EqualFold(value, "mobile") {
+			return fmt.Errorf("expected fixed or mobile")
+		}
+		planFilter.fixedOrMobile = value
+		return nil
+	})
+	flags.Func("rows", "only these comma separated csv rows or ranges of rows, eg: 2-10,15,20-", func(value string) error {
+		ranges, err := parseRowRanges(value)
+		planFilter.rows = append(planFilter.rows, ranges...)
+		return err
+	})
+	flags.Func("where", "only the rows matching an expression of csv columns, eg: \"dl_speed_in_kbps >= 100000 and acp = Yes\", can be repeated", func(value string) error {
+		expression, err := parseFilterExpression(value)
+		planFilter.where = append(planFilter.where, expression)
+		return err
+	})
+}
+
+// listFlag is an option that can be given more than once.
+type listFlag []string
+
+func (l *listFlag) String() string {
+	if l == nil {
+		return ""
+	}
+	return strings.Join(*l, ",")
+}
+
+func (l *listFlag) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
+// commaListFlag is an option that can be given more than once, each time with
+// a comma separated list of values.
+type commaListFlag struct {
+	values *[]string
+}
+
+func (l *commaListFlag) String() string {
+	if l == nil || l.values == nil {
+		return ""
+	}
+	return strings.Join(*l.values, ",")
+}
+
+func (l *commaListFlag) Set(value string) error {
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			*l.values = append(*l.values, item)
+		}
+	}
+	return nil
+}
+
+func (f rowFilter) active() bool {
+	return len(f.companies) > 0 || len(f.planIDs) > 0 || len(f.serviceIDs) > 0 || f.fixedOrMobile != "" || len(f.rows) > 0 || len(f.where) > 0
+}
+
+// checkColumns makes sure the expressions only use columns of the csv header,
+// a misspelled column would otherwise leave out every row.
+func (f rowFilter) checkColumns(header []string) error {
+	columns := make(map[string]bool, len(header))
+	for _, column := range header {
+		columns[column] = true
+	}
+	for _, expression := range f.where {
+		for _, alternative := range expression {
+			for _, condition := range alternative {
+				if !columns[condition.column] {
+					return fmt.Errorf("-where: the csv has no column %q", condition.column)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// matches reports whether the row of data is selected, data being a row of
+// csvRowReader.next with its csv row number.
+func (f rowFilter) matches(data map[string]string, row int) bool {
+	if len(f.companies) > 0 && !containsFold(f.companies, data["company_name"]) {
+		return false
+	}
+	if len(f.serviceIDs) > 0 && !containsFold(f.serviceIDs, data["data_service_id"]) {
+		return false
+	}
+
+	fixedOrMobile := data["fixed_or_mobile"]
+	if fixedOrMobile == "" {
+		// as newBroadbandData defaults it
+		fixedOrMobile = "Fixed"
+	}
+	if f.fixedOrMobile != "" && !strings.EqualFold(f.fixedOrMobile, fixedOrMobile) {
+		return false
+	}
+	if len(f.planIDs) > 0 {
+		planID := uniquePlanID(BroadbandData{FixedOrMobile: fixedOrMobile, FccID: data["fcc_id"], DataServiceID: data["data_service_id"]})
+		if !containsFold(f.planIDs, planID) {
+			return false
+		}
+	}
+
+	if len(f.rows) > 0 {
+		inRange := false
+		for _, rows := range f.rows {
+			inRange = inRange || rows.contains(row)
+		}
+		if !inRange {
+			return false
+		}
+	}
+
+	for _, expression := range f.where {
+		if !expression.matches(data) {
+			return false
+		}
+	}
+	return true
+}
+
+func containsFold(values []string, value string) bool {
+	for _, v := range values {
+		if strings.EqualFold(strings.TrimSpace(v), strings.TrimSpace(value)) {
+			return true
+		}
+	}
+	return false
+}
+
+// rowRange is a range of csv rows, last is 0 for a range without an end.
+type rowRange struct {
+	first, last int
+}
+
+func (r rowRange) contains(row int) bool {
+	return row >= r.first && (r.last == 0 || row <= r.last)
+}
+
+func parseRowRanges(value string) ([]rowRange, error) {
+	var ranges []rowRange
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		firstRow, lastRow, isRange := strings.Cut(item, "-")
+		first, err := strconv.Atoi(strings.TrimSpace(firstRow))
+		if err != nil || first < 2 {
+			return nil, fmt.Errorf("%q isn't a csv row, rows start at 2 after the header", item)
+		}
+
+		last := first
+		if isRange {
+			last = 0
+			if lastRow = strings.TrimSpace(lastRow); lastRow != "" {
+				if last, err = strconv.Atoi(lastRow); err != nil || last < first {
+					return nil, fmt.Errorf("%q isn't a range of csv rows", item)
+				}
+			}
+		}
+		ranges = append(ranges, rowRange{first, last})
+	}
+	return ranges, nil
+}
+
+// filterExpression is a list of alternatives joined by or, each a list of
+// conditions joined by and.
+type filterExpression [][]filterCondition
+
+type filterCondition struct {
+	column, operator, value string
+}
+
+var (
+	filterConditionPattern = regexp.MustCompile(`^\s*([A-Za-z0-9_]+)\s*(==|!=|<=|>=|=|<|>)\s*("[^"]*"|'[^']*'|[^\s"']+)`)
+	filterJoinPattern      = regexp.MustCompile(`^\s+(?i:(and|or))\s`)
+)
+
+// parseFilterExpression parses conditions comparing a csv column with a
+// value, like dl_speed_in_kbps >= 100000, joined by and or or. Values with
+// spaces are quoted.
+func parseFilterExpression(text string) (filterExpression, error) {
+	expression := filterExpression{nil}
+	rest := text
+	for {
+		match := filterConditionPattern.FindStringSubmatch(rest)
+		if match == nil {
+			return nil, fmt.Errorf("expected a condition like dl_speed_in_kbps >= 100000 at %q", strings.TrimSpace(rest))
+		}
+		value := match[3]
+		if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') {
+			value = value[1 : len(value)-1]
+		}
+		last := len(expression) - 1
+		expression[last] = append(expression[last], filterCondition{strings.ToLower(match[1]), match[2], value})
+
+		rest = rest[len(match[0]):]
+		if strings.TrimSpace(rest) == "" {
+			return expression, nil
+		}
+		join := filterJoinPattern.FindStringSubmatch(rest)
+		if join == nil {
+			return nil, fmt.Errorf("expected and or or at %q", strings.TrimSpace(rest))
+		}
+		if strings.EqualFold(join[1], "or") {
+			expression = append(expression, nil)
+		}
+		rest = rest[len(join[0]):]
+	}
+}
+
+func (e filterExpression) matches(data map[string]string) bool {
+	for _, all := range e {
+		matched := true
+		for _, condition := range all {
+			matched = matched && condition.matches(data[condition.column])
+		}
+		if matched {
+			return true
+		}
+	}
+	return false
+}
+
+// matches compares numbers as numbers and text ignoring case. An ordering
+// against a number is false for a value that isn't one, such as an empty
+// value.
+func (c filterCondition) matches(value string) bool {
+	value = strings.TrimSpace(value)
+	want, wantErr := strconv.ParseFloat(c.value, 64)
+	have, haveErr := strconv.ParseFloat(strings.TrimPrefix(value, "$"), 64)
+
+	comparison := 0
+	switch {
+	case wantErr == nil && haveErr == nil:
+		if have < want {
+			comparison = -1
+		} else if have > want {
+			comparison = 1
+		}
+	case wantErr == nil && c.operator != "=" && c.operator != "==" && c.operator != "!=":
+		return false
+	default:
+		comparison = strings.Compare(strings.ToLower(value), strings.ToLower(c.value))
+	}
+
+	switch c.operator {
+	case "=", "==":
+		return comparison == 0
+	case "!=":
+		return comparison != 0
+	case "<":
+		return comparison < 0
+	case "<=":
+		return comparison <= 0
+	case ">":
+		return comparison > 0
+	default:
+		return comparison >= 0
+	}
+}
@@ -2,6 +2,9 @@ module github.com/SonarSoftwareInc/sonarbcd
 
 go 1.20
 
-require github.com/davecgh/go-spew v1.1.1
-
-require github.com/ajstarks/svgo v0.0.0-20211024235047-1546f124cd8b // indirect
+require (
+	github.com/BurntSushi/toml v1.5.0
+	github.com/ajstarks/svgo v0.0.0-20211024235047-1546f124cd8b
+	golang.org/x/sys v0.9.0
+	gopkg.in/yaml.v3 v3.0.1
+)
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const (
+	groupByNone    = "none"
+	groupByCompany = "company"
+
+	groupIndexFileName = "index.csv"
+)
+
+// labelSettings are how the rows of a csv file are selected, grouped and
+// named, as set by the flags of a command.
+type labelSettings struct {
+	groupBy         string
+	fileNamePattern string
+	filter          rowFilter
+}
+
+// commandLabelSettings are the label settings of the parsed flags.
+func commandLabelSettings() labelSettings {
+	return labelSettings{groupBy: groupBy, fileNamePattern: fileNamePattern, filter: planFilter}
+}
+
+// labelGroup is a set of labels written to the same directory and zip file.
+type labelGroup struct {
+	name       string
+	directory  string
+	outputPath string
+	zipName    string
+	fileNamer  *labelFileNamer
+	labels     []labelRecord
+}
+
+// labelRecord is what is kept of a label once it has been handed to the
+// pipeline, for the group index and manifest.
+type labelRecord struct {
+	fileName      string
+	planID        string
+	company       string
+	serviceName   string
+	dataServiceID string
+	fixedOrMobile string
+	csvRow        int
+}
+
+// labelGroups assigns each plan to the group selected by -groupby as the csv
+// is read. Without grouping there is a single group for directory.
+type labelGroups struct {
+	groupBy   string
+	directory string
+	pattern   string
+	groups    []*labelGroup
+	byName    map[string]*labelGroup
+}
+
+func newLabelGroups(groupBy, directory, pattern string) (*labelGroups, error) {
+	g := &labelGroups{groupBy: groupBy, directory: directory, pattern: pattern, byName: make(map[string]*labelGroup)}
+
+	switch groupBy {
+	case groupByNone:
+		fileNamer, err := newLabelFileNamer(pattern)
+		if err != nil {
+			return nil, err
+		}
+		g.groups = []*labelGroup{{directory: directory, zipName: zipName, fileNamer: fileNamer}}
+	case groupByCompany:
+	default:
+		return nil, fmt.Errorf("unknown groupby %q, expected %s or %s", groupBy, groupByNone, groupByCompany)
+	}
+	return g, nil
+}
+
+// add returns the group and file name of a plan.
+func (g *labelGroups) add(template BroadbandData) (*labelGroup, string, error) {
+	group, err := g.groupOf(template)
+	if err != nil {
+		return nil, "", err
+	}
+
+	fileName, err := group.fileNamer.next(template)
+	if err != nil {
+		return nil, "", err
+	}
+
+	// the values are cloned, the csv reader keeps the whole row in a single
+	// string that would otherwise stay in memory for as long as the record
+	group.labels = append(group.labels, labelRecord{
+		fileName:      fileName,
+		planID:        uniquePlanID(template),
+		company:       strings.Clone(template.CompanyName),
+		serviceName:   strings.Clone(template.DataServiceName),
+		dataServiceID: strings.Clone(template.DataServiceID),
+		fixedOrMobile: strings.Clone(template.FixedOrMobile),
+		csvRow:        template.CsvRow,
+	})
+	return group, fileName, nil
+}
+
+func (g *labelGroups) groupOf(template BroadbandData) (*labelGroup, error) {
+	if g.groupBy == groupByNone {
+		return g.groups[0], nil
+	}
+
+	name := slugify(template.CompanyName)
+	if name == "" {
+		return nil, fmt.Errorf("company_name %q can't be used as a directory name", template.CompanyName)
+	}
+
+	// companies are kept in csv order, compared case insensitively as some
+	// file systems do
+	group, ok := g.byName[strings.ToLower(name)]
+	if ok {
+		if group.name != template.CompanyName {
+			return nil, fmt.Errorf("company_name %q and %q share the directory %s", group.name, template.CompanyName, name)
+		}
+		return group, nil
+	}
+
+	fileNamer, err := newLabelFileNamer(g.pattern)
+	if err != nil {
+		return nil, err
+	}
+	group = &labelGroup{
+		name:       template.CompanyName,
+		directory:  filepath.Join(g.directory, name),
+		outputPath: name,
+		zipName:    strings.TrimSuffix(zipName, ".zip") + "-" + name,
+		fileNamer:  fileNamer,
+	}
+	g.byName[strings.ToLower(name)] = group
+	g.groups = append(g.groups, group)
+	return group, nil
+}
+
+// writeGroupIndex lists the plans of a group, one row per label.
+func writeGroupIndex(group *labelGroup) error {
+	indexFile, err := os.Create(filepath.Join(group.directory, groupIndexFileName))
+	if err != nil {
+		return err
+	}
+	defer indexFile.Close()
+
+	writer := csv.NewWriter(indexFile)
+	writer.Write([]string{"file_name", "unique_plan_identifier", "company_name", "data_service_name", "data_service_id", "fixed_or_mobile", "csv_row"})
+	for _, label := range group.labels {
+		writer.Write([]string{
+			label.fileName,
+			label.planID,
+			label.company,
+			label.serviceName,
+			label.dataServiceID,
+			label.fixedOrMobile,
+			strconv.Itoa(label.csvRow),
+		})
+	}
+	writer.Flush()
+	if err := writer.Error(); err != nil {
+		return err
+	}
+	return indexFile.Close()
+}
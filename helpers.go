@@ -37,3 +37,23 @@ func convertErrorToJSON(row string, messages ...string) error {
 	json, _ := json.Marshal(j)
 	return errors.New(string(json))
 }
+
+// errorToJSON returns err as it is when it already is a json error, such as
+// the csv validation errors, and in the convertErrorToJSON format otherwise.
+func errorToJSON(err error) error {
+	var j jsonError
+	if json.Unmarshal([]byte(err.Error()), &j) == nil && j.IsError == "true" {
+		return err
+	}
+	return convertErrorToJSON("NA", err.Error())
+}
+
+// errorMessage returns the csv row and message of a json error, or no row and
+// the error text of any other error.
+func errorMessage(err error) (string, string) {
+	var j jsonError
+	if json.Unmarshal([]byte(err.Error()), &j) == nil && j.IsError == "true" {
+		return j.Row, j.Message
+	}
+	return "", err.Error()
+}
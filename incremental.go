@@ -0,0 +1,154 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sync"
+)
+
+// labelCache decides which labels have to be rendered. A label is reused from
+// the output directory when its hash, of the plan and everything else the
+// rendered label depends on, matches the hash recorded by the previous run.
+type labelCache struct {
+	outputDirectory string
+	renderSettings  string
+	previous        map[string]string
+
+	// guards the fields below, labels are generated concurrently
+	mutex     sync.Mutex
+	hashes    map[string]string
+	created   int
+	updated   int
+	unchanged int
+}
+
+// labelCounts is what a run did to the labels: the labels it rendered for
+// the first time, rendered again or took from the previous run, and the stale
+// labels of earlier runs it removed with -prune or kept.
+type labelCounts struct {
+	created, updated, unchanged, removed, stale int
+}
+
+func (c labelCounts) String() string {
+	return fmt.Sprintf("%d created, %d updated, %d unchanged, %d removed, %d stale", c.created, c.updated, c.unchanged, c.removed, c.stale)
+}
+
+func newLabelCache(outputDirectory string, previous outputState) (*labelCache, error) {
+	settings, err := renderSettingsHash()
+	if err != nil {
+		return nil, err
+	}
+	return &labelCache{
+		outputDirectory: outputDirectory,
+		renderSettings:  settings,
+		previous:        previous.Labels,
+		hashes:          make(map[string]string),
+	}, nil
+}
+
+// renderSettingsHash covers everything other than the plan itself that ends
+// up in a label.
+func renderSettingsHash() (string, error) {
+	settings, err := json.Marshal(struct {
+		Generator     string
+		Templates     string
+		Layout        string
+		Columns       int
+		QRBaseURL     string
+		QRCode        bool
+		LinkColor     string
+		RuleColor     string
+		Logo          string
+		LogoMaxWidth  int
+		LogoMaxHeight int
+	}{
+		Generator:     generatorVersion(),
+		Templates:     labelTemplatesVersion,
+		Layout:        layoutName,
+		Columns:       layoutColumns,
+		QRBaseURL:     qrBaseURL,
+		QRCode:        qrEnabled,
+		LinkColor:     labelTheme.LinkColor,
+		RuleColor:     labelTheme.RuleColor,
+		Logo:          labelTheme.logoDataURI,
+		LogoMaxWidth:  labelTheme.LogoMaxWidth,
+		LogoMaxHeight: labelTheme.LogoMaxHeight,
+	})
+	if err != nil {
+		return "", err
+	}
+	hash := sha256.Sum256(settings)
+	return hex.EncodeToString(hash[:]), nil
+}
+
+// labelHash is the hash of the normalized plan and the render settings. The
+// csv row isn't part of a label, so moving a row doesn't change its hash.
+func (c *labelCache) labelHash(template BroadbandData) (string, error) {
+	template.CsvRow = 0
+	plan, err := json.Marshal(template)
+	if err != nil {
+		return "", err
+	}
+
+	hash := sha256.New()
+	fmt.Fprintf(hash, "%s\n", c.renderSettings)
+	hash.Write(plan)
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// reuse links the label written by the previous run to target when the plan
+// is unchanged. It returns false when the label has to be rendered.
+func (c *labelCache) reuse(label, hash, target string) bool {
+	if c.previous[label] != hash {
+		return false
+	}
+	previous := filepath.Join(c.outputDirectory, filepath.FromSlash(label))
+	if err := os.Link(previous, target); err != nil && copyFile(previous, target) != nil {
+		return false
+	}
+	return true
+}
+
+// keep links the label written by the previous run to target whatever its
+// hash, for a row left out by the row filter. It returns false when the
+// previous run didn't write the label.
+func (c *labelCache) keep(label, target string) bool {
+	hash, ok := c.previous[label]
+	if !ok {
+		return false
+	}
+	previous := filepath.Join(c.outputDirectory, filepath.FromSlash(label))
+	if err := os.Link(previous, target); err != nil && copyFile(previous, target) != nil {
+		return false
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.hashes[label] = hash
+	return true
+}
+
+func (c *labelCache) record(label, hash string, reused bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	switch _, existed := c.previous[label]; {
+	case reused:
+		c.unchanged++
+	case existed:
+		c.updated++
+	default:
+		c.created++
+	}
+	c.hashes[label] = hash
+}
+
+// labelPath is the path of a label relative to the output directory.
+func (g *labelGroup) labelPath(fileName string) string {
+	return path.Join(g.outputPath, fileName)
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"encoding/base64"
+	"html/template"
+	"io"
+	"regexp"
+	"strings"
+)
+
+// labelTextPattern matches the text elements of a rendered label, each is
+// written on a line of its own.
+var labelTextPattern = regexp.MustCompile(`<text([^>]*)>([^<]*)</text>`)
+
+// changedTextStyle is added to a label to highlight the text elements marked
+// with the changed class. It overrides the fill set by the label templates.
+const changedTextStyle = `<style>.changed { fill: #cf222e !important; font-weight: 900 !important; text-decoration: underline; }</style>`
+
+// labelComparison is one plan of the labels report.
+type labelComparison struct {
+	planChange
+	Old, New template.URL
+	// Unchanged is set when the fields that changed aren't on the label.
+	Unchanged bool
+}
+
+// writePlanDiffLabels renders the old and new label of each changed plan and
+// writes them side by side, with the text that changed highlighted in each.
+// Added and removed plans have a single label.
+func writePlanDiffLabels(w io.Writer, diff planDiff) error {
+	comparisons := make([]labelComparison, 0, len(diff.Changes))
+	for _, change := range diff.Changes {
+		oldLabel, err := renderLabelSVG(change.oldPlan)
+		if err != nil {
+			return err
+		}
+		newLabel, err := renderLabelSVG(change.newPlan)
+		if err != nil {
+			return err
+		}
+
+		comparison := labelComparison{planChange: change, Unchanged: oldLabel == newLabel}
+		if oldLabel != "" {
+			comparison.Old = svgDataURL(highlightChangedText(oldLabel, newLabel))
+		}
+		if newLabel != "" {
+			comparison.New = svgDataURL(highlightChangedText(newLabel, oldLabel))
+		}
+		comparisons = append(comparisons, comparison)
+	}
+
+	added, removed, changed := diff.counts()
+	return labelDiffTemplate.Execute(w, struct {
+		planDiff
+		Comparisons             []labelComparison
+		Added, Removed, Changed int
+	}{diff, comparisons, added, removed, changed})
+}
+
+// renderLabelSVG returns the label of plan, or an empty label when there is
+// no plan.
+func renderLabelSVG(plan *BroadbandData) (string, error) {
+	if plan == nil {
+		return "", nil
+	}
+	var label strings.Builder
+	if err := renderLabel(&label, *plan); err != nil {
+		return "", err
+	}
+	return label.String(), nil
+}
+
+// highlightChangedText marks the text elements of label that other doesn't
+// have. The text is compared rather than the position, so text moved by a
+// line added above it isn't highlighted.
+func highlightChangedText(label, other string) string {
+	if other == "" {
+		return label
+	}
+
+	remaining := make(map[string]int)
+	for _, match := range labelTextPattern.FindAllStringSubmatch(other, -1) {
+		remaining[match[2]]++
+	}
+
+	changed := false
+	label = labelTextPattern.ReplaceAllStringFunc(label, func(element string) string {
+		text := labelTextPattern.FindStringSubmatch(element)[2]
+		if remaining[text] > 0 {
+			remaining[text]--
+			return element
+		}
+		changed = true
+		return `<text class="changed"` + strings.TrimPrefix(element, "<text")
+	})
+	if !changed {
+		return label
+	}
+
+	// the style goes right after the opening svg element
+	svgStart := strings.Index(label, "<svg")
+	if svgStart < 0 {
+		return label
+	}
+	svgEnd := svgStart + strings.Index(label[svgStart:], ">") + 1
+	return label[:svgEnd] + changedTextStyle + label[svgEnd:]
+}
+
+// svgDataURL embeds a label in the report as an image, which keeps the styles
+// and ids of the labels apart.
+func svgDataURL(svg string) template.URL {
+	return template.URL("data:image/svg+xml;base64," + base64.StdEncoding.EncodeToString([]byte(svg)))
+}
+
+var labelDiffTemplate = template.Must(template.New("labels").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>{{.OldFile}} to {{.NewFile}}</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+.labels { display: flex; gap: 2em; margin-bottom: 3em; }
+.labels figure { margin: 0; width: 431px; }
+.labels img { width: 100%; border: 1px solid #ccc; }
+.missing { display: flex; align-items: center; justify-content: center; height: 200px; border: 1px dashed #ccc; color: #666; }
+</style>
+</head>
+<body>
+<h1>{{.OldFile}} to {{.NewFile}}</h1>
+<p>{{.Added}} added, {{.Removed}} removed, {{.Changed}} changed. Text that differs between the labels is highlighted.</p>
+{{range .Comparisons}}<section>
+<h2>{{.Change}}: {{.CompanyName}} {{.DataServiceName}}</h2>
+<p>{{.PlanID}}{{if .OldCsvRow}}, old csv row {{.OldCsvRow}}{{end}}{{if .NewCsvRow}}, new csv row {{.NewCsvRow}}{{end}}{{if .Unchanged}}, the changed fields aren't shown on the label{{end}}</p>
+<div class="labels">
+<figure>{{if .Old}}<img src="{{.Old}}" alt="old label of {{.PlanID}}">{{else}}<div class="missing">not in the old csv</div>{{end}}<figcaption>Before</figcaption></figure>
+<figure>{{if .New}}<img src="{{.New}}" alt="new label of {{.PlanID}}">{{else}}<div class="missing">not in the new csv</div>{{end}}<figcaption>After</figcaption></figure>
+</div>
+</section>
+{{end}}</body>
+</html>
+`))
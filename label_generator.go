@@ -1,415 +1,377 @@
 package main
 
 import (
-	"fmt"
-	"log"
+	"bufio"
+	"io"
 	"os"
+	"path/filepath"
 	"strconv"
 	"strings"
 
 	svg "github.com/ajstarks/svgo"
 )
 
+// text styles, loaded from the style_* templates by loadLabelTemplates
 var (
-	width                  = 431
-	xMargin                = 14
-	xParagraph             = 35
-	xFeeLine               = 55
-	xMarginRightIndent     = 25
-	xMarginRightIndentHard = 106
-	xIndent                = 29
+	labelTitle                                 string
+	labelTitleAnchorEnd                        string
+	labelCompanyName                           string
+	labelPackageName                           string
+	labelGenericTextNormal                     string
+	labelGenericTextNormalBold                 string
+	labelGenericTextNormalBoldAnchorEnd        string
+	labelGenericTextNormalHeavyBoldAnchorEnd   string
+	labelGenericTextNormalHeavyBoldAnchorStart string
+	labelMonthlyPrice                          string
+	labelMonthlyPriceValue                     string
+	labelSectionHeading                        string
+	labelFccLink                               string
+	labelUniquePlanId                          string
 )
 
-const (
-	labelTitle                                 = "font-size:36pt;font-weight:900;font-family:'Roboto Flex';letter-spacing:0em"
-	labelCompanyName                           = "font-size:18pt;letter-spacing:0em;font-weight:bold;font-family:'Roboto';text-anchor:left"
-	labelPackageName                           = "font-size:14pt;letter-spacing:0em;font-weight:800;font-family:'Roboto Flex';text-anchor:left"
-	labelGenericTextNormal                     = "font-size:12pt;letter-spacing:0em;font-family:Roboto;text-anchor:left"
-	labelGenericTextNormalAnchorEnd            = "font-size:12pt;letter-spacing:0em;font-family:Roboto;text-anchor:end"
-	labelGenericTextNormalBold                 = "font-size:12pt;letter-spacing:0em;font-weight:900;font-family:Roboto;text-anchor:left"
-	labelGenericTextNormalBoldAnchorEnd        = "font-size:12pt;letter-spacing:0em;font-weight:bold;font-family:'Roboto Flex';text-anchor:end"
-	labelGenericTextNormalBoldAnchorStart      = "font-size:12pt;letter-spacing:0em;font-weight:bold;font-family:'Roboto Flex';text-anchor:start"
-	labelGenericTextNormalHeavyBoldAnchorEnd   = "font-size:12pt;letter-spacing:0em;font-weight:900;font-family:Roboto;text-anchor:end"
-	labelGenericTextNormalHeavyBoldAnchorStart = "font-size:12pt;letter-spacing:0em;font-weight:900;font-family:Roboto;text-anchor:start"
-	labelGenericTextSmall                      = "font-size:10pt;letter-spacing:0em;font-family:Roboto;text-anchor:left"
-	labelGenericTextSmallBoldAnchorEnd         = "font-size:10pt;letter-spacing:0em;font-weight:900;font-family:Roboto;text-anchor:end"
-	labelMonthlyPrice                          = "font-size:18pt;letter-spacing:0em;font-weight:800;font-family:'Roboto Flex';text-anchor:left"
-	labelMonthlyPriceValue                     = "font-size:18pt;letter-spacing:0em;font-weight:800;font-family:'Roboto Flex';text-anchor:end"
-	labelSectionHeading                        = "font-size:14pt;letter-spacing:0em;font-weight:bold;font-family:'Roboto Flex';text-anchor:left"
-	labelFccLink                               = "font-size:14pt;letter-spacing:0em;font-family:'Roboto Flex';text-anchor:end"
-	labelUniquePlanId                          = "font-size:12pt;letter-spacing:0em;font-family:Roboto;text-anchor:left"
-)
+const qrCodeSize = 96
 
-var templateStyle = `
-    <style type="text/css">
-       @import url('https://fonts.googleapis.com/css2?family=Roboto:wght@400;500;700;900');
-       @import url('https://fonts.googleapis.com/css2?family=Roboto+Flex:opsz,wght@8..144,400;8..144,500;8..144,600;8..144,700;8..144,800;8..144,900;8..144,1000');                a:link,
-       a:hover,
-       a:active,
-       a:visited {
-           fill: #0000EE;
-       }
-	   a:hover {
-	       text-decoration: underline;
-       }
-	</style>
-`
-
-func setTemplateStyles(canvas *svg.SVG) {
-	canvas.Def()
-	fmt.Fprintln(canvas.Writer, templateStyle)
-	canvas.DefEnd()
-}
+// the section functions below only describe the content of each part of the
+// label, the layout pass in layout.go works out where everything goes. They
+// return the error of the first text template that fails.
 
-type BroadbandConsumerLabel struct {
-	yCounter int
+func labelTitleSection(template LabelTemplateData) ([]layoutRow, error) {
+	texts := &labelTexts{}
+	return []layoutRow{
+		textRow(spaceTitle, indentMargin, texts.text("title_broadband", template), labelTitle).
+			withValue(alignRight, span(texts.text("title_facts", template), labelTitleAnchorEnd)),
+		ruleRow(spaceTight, 1),
+	}, texts.err
 }
 
-func (b *BroadbandConsumerLabel) addY(offset int) int {
-	b.yCounter += offset
-	return b.yCounter
-}
+func providerBlock(template LabelTemplateData) ([]layoutRow, error) {
+	texts := &labelTexts{}
+	var rows []layoutRow
+	companyName := textRow(spaceHeadingLarge, indentMargin, template.CompanyName, labelCompanyName)
+	packageName := textRow(spaceLead, indentMargin, template.DataServiceName, labelPackageName)
+	if labelTheme.logoDataURI != "" {
+		rows = append(rows, boxRow(layoutBox{
+			offset: 8,
+			width:  labelTheme.LogoMaxWidth,
+			height: labelTheme.LogoMaxHeight,
+			href:   labelTheme.logoDataURI,
+		}))
+		// the names wrap rather than run under the logo
+		companyName = companyName.besideBox(labelTheme.LogoMaxWidth, spaceHeadingLarge)
+		packageName = packageName.besideBox(labelTheme.LogoMaxWidth, spaceLead)
+	}
 
-func (b *BroadbandConsumerLabel) getY() int {
-	return b.yCounter
+	return append(rows,
+		companyName,
+		packageName,
+		textRow(spaceLead, indentMargin, texts.text("service_type", template), labelGenericTextNormal),
+		ruleRow(spaceRule, 12),
+	), texts.err
 }
 
-func (b *BroadbandConsumerLabel) labelTitle(canvas *svg.SVG, thisSectionYStart int) {
-	canvas.Gstyle("")
-	canvas.Text(xMargin, b.addY(55), "Broadband", labelTitle)
-	canvas.Text(285, b.getY(), "Facts", labelTitle)
-	canvas.Line(xMargin, b.addY(4), width-xMargin, b.getY(), "stroke:black;stroke-width:1")
-	canvas.Gend()
+func monthlyPrice(template LabelTemplateData) ([]layoutRow, error) {
+	texts := &labelTexts{}
+	rows := []layoutRow{
+		textRow(spaceHeadline, indentMargin, texts.text("monthly_price", template), labelMonthlyPrice).
+			withValue(alignRight, span("$"+template.MonthlyPrice, labelMonthlyPriceValue)),
+	}
+	if template.BillingFrequencyInMonths != "1" && template.BillingPeriodPrice != "" {
+		rows = append(rows, textRow(spaceLine, indentSubItem, texts.text("billing_period", template), labelGenericTextNormal).
+			withValue(alignRight, span("$"+template.BillingPeriodPrice, labelGenericTextNormalBoldAnchorEnd)))
+	}
+	return append(rows, ruleRow(spaceRuleTight, 3)), texts.err
 }
 
-func (b *BroadbandConsumerLabel) providerBlock(canvas *svg.SVG, thisSectionYStart int, template BroadbandData, fontList string) {
-	canvas.Gstyle("")
-	canvas.Text(xMargin, b.addY(25), template.CompanyName, labelCompanyName)
-	canvas.Text(xMargin, b.addY(20), template.DataServiceName, labelPackageName)
-	providerServiceType := ""
-	if template.FixedOrMobile == "Fixed" {
-		providerServiceType = "Fixed Broadband Consumer Disclosure"
-	} else {
-		providerServiceType = "Mobile Broadband Consumer Disclosure"
+func monthlyDetails(template LabelTemplateData) ([]layoutRow, error) {
+	texts := &labelTexts{}
+	// is introductory or not?
+	if !template.IntroductoryRate {
+		return []layoutRow{
+			textRow(spaceLine, indentMargin, texts.text("not_introductory", template), labelGenericTextNormal),
+			textRow(spaceLine, indentMargin, texts.text("no_contract", template), labelGenericTextNormal),
+			ruleRow(spaceRule, 1),
+		}, texts.err
 	}
-	canvas.Text(xMargin, b.addY(21), providerServiceType, labelGenericTextNormal)
-	canvas.Line(xMargin, b.addY(9), width-xMargin, b.getY(), "stroke:black;stroke-width:12")
-	canvas.Gend()
-}
 
-func (b *BroadbandConsumerLabel) monthlyPrice(canvas *svg.SVG, thisSectionYStart int, template BroadbandData, fontList string) {
-	canvas.Gstyle("")
-	canvas.Text(xMargin, b.addY(30), "Monthly Price", labelMonthlyPrice)
-	canvas.Text((width - xMargin), b.getY(), "$"+template.MonthlyPrice+"", labelMonthlyPriceValue)
-	canvas.Line(xMargin, b.addY(8), width-xMargin, b.getY(), "stroke:black;stroke-width:3")
-	canvas.Gend()
-}
+	rows := []layoutRow{
+		textRow(spaceLead, indentMargin, texts.text("introductory", template), labelGenericTextNormal),
+	}
 
-func (b *BroadbandConsumerLabel) monthlyDetails(canvas *svg.SVG, thisSectionYStart int, template BroadbandData, fontList string) {
-	canvas.Gstyle("")
-	// is introductory or not?
-	if template.IntroductoryRate {
-		canvas.Text(xMargin, b.addY(20), "This Monthly Price is an introductory rate.", labelGenericTextNormal)
-		lineY := b.addY(14)
-		canvas.Text(xIndent, lineY, "Introductory Period", labelGenericTextNormal)
-		canvas.Text((width - xMargin), lineY, template.IntroductoryPeriodInMonths+" months", labelGenericTextNormalBoldAnchorEnd)
-		lineY = b.addY(17)
-		canvas.Text(xIndent, lineY, "Price after introductory period", labelGenericTextNormal)
-		canvas.Text((width - xMargin), lineY, "$"+template.DataServicePrice, labelGenericTextNormalBoldAnchorEnd)
-		contractDuration, err := strconv.Atoi(template.ContractDuration)
-		if err != nil {
-			log.Fatalln("error:", err)
-			return
-		}
-		aOrAn := ""
-		if contractDuration == 8 || contractDuration == 11 || contractDuration == 18 {
-			aOrAn = "an"
-		} else {
-			aOrAn = "a"
+	if len(template.PriceSchedule) > 1 {
+		// multi step promos list every step, then the regular price
+		startMonth := 1
+		for i, step := range template.PriceSchedule {
+			endMonth := startMonth + step.PeriodInMonths - 1
+			advance := spaceLine
+			if i == 0 {
+				advance = spaceLineTight
+			}
+			stepData := PromoStepTemplateData{LabelTemplateData: template, StartMonth: startMonth, EndMonth: endMonth, PricePerMonth: step.PricePerMonth}
+			rows = append(rows, textRow(advance, indentSubItem, texts.text("promo_step", stepData), labelGenericTextNormal).
+				withValue(alignRight, span("$"+step.PricePerMonth, labelGenericTextNormalBoldAnchorEnd)))
+			startMonth = endMonth + 1
 		}
-		contractTerms := "This Monthly Price requires " + aOrAn + " " + template.ContractDuration + " month"
-		canvas.Text(xMargin, b.addY(17), contractTerms, labelGenericTextNormal)
-		canvas.Textspan(width-xMarginRightIndent-50, b.getY(), "", labelGenericTextNormalAnchorEnd)
-		canvas.Link(template.ContractURL, "contract")
-		canvas.Span("contract", "fill:blue")
-		canvas.LinkEnd()
-		canvas.TextEnd()
+		afterData := PromoStepTemplateData{LabelTemplateData: template, StartMonth: startMonth, EndMonth: startMonth - 1, PricePerMonth: template.DataServicePrice}
+		rows = append(rows, textRow(spaceLine, indentSubItem, texts.text("price_after_promo", afterData), labelGenericTextNormal).
+			withValue(alignRight, span("$"+template.DataServicePrice, labelGenericTextNormalBoldAnchorEnd)))
 	} else {
-		canvas.Text(xMargin, b.addY(17), "This Monthly Price is not an introductory rate.", labelGenericTextNormal)
-		canvas.Text(xMargin, b.addY(17), "This Monthly Price does not require a contract.", labelGenericTextNormal)
+		introductoryData := IntroductoryTemplateData{LabelTemplateData: template, IntroductoryMonths: template.IntroductoryPeriodInMonths}
+		if len(template.PriceSchedule) == 1 {
+			introductoryData.IntroductoryMonths = strconv.Itoa(template.PriceSchedule[0].PeriodInMonths)
+		}
+		rows = append(rows,
+			textRow(spaceLineTight, indentSubItem, texts.text("introductory_period", template), labelGenericTextNormal).
+				withValue(alignRight, span(texts.text("introductory_period_value", introductoryData), labelGenericTextNormalBoldAnchorEnd)),
+			textRow(spaceLine, indentSubItem, texts.text("price_after_introductory", template), labelGenericTextNormal).
+				withValue(alignRight, span("$"+template.DataServicePrice, labelGenericTextNormalBoldAnchorEnd)),
+		)
 	}
-	lineY := b.addY(12)
-	canvas.Line(xMargin, lineY, width-xMargin, lineY, "stroke:black;stroke-width:1")
-	canvas.Gend()
+
+	// a promotion on a plan without a contract, such as month to month
+	if _, hasContract, _ := parseContractDuration(template.ContractDuration); !hasContract {
+		return append(rows,
+			textRow(spaceLine, indentMargin, texts.text("no_contract", template), labelGenericTextNormal),
+			ruleRow(spaceRule, 1),
+		), texts.err
+	}
+	return append(rows,
+		flowRow(spaceLine, indentMargin,
+			span(texts.text("contract_terms", template)+" ", labelGenericTextNormal),
+			linkSpan(texts.text("contract_link", template), template.ContractURL, labelGenericTextNormal)),
+		ruleRow(spaceRule, 1),
+	), texts.err
 }
 
 // TODO limit to 37 characters or less..
-func (b *BroadbandConsumerLabel) additionalChargesAndTerms(canvas *svg.SVG, thisSectionYStart int, template BroadbandData, fontList string) {
-	canvas.Gstyle("")
-	canvas.Text(xMargin, b.addY(23), "Additional Charges & Terms", labelSectionHeading)
-
-	canvas.Text(xParagraph, b.addY(17), "Provider Monthly Fees", labelGenericTextNormal)
+func additionalChargesAndTerms(template LabelTemplateData) ([]layoutRow, error) {
+	texts := &labelTexts{}
+	rows := []layoutRow{
+		textRow(spaceHeading, indentMargin, texts.text("additional_charges", template), labelSectionHeading),
+		textRow(spaceLine, indentParagraph, texts.text("provider_monthly_fees", template), labelGenericTextNormal),
+	}
 	if len(template.ExtraMonthlyFields) > 0 {
 		for _, charge := range template.ExtraMonthlyFields {
-			charge.ChargeValue = strings.TrimPrefix(charge.ChargeValue, "$")
-			canvas.Text(xFeeLine, b.addY(17), charge.ChargeName, labelGenericTextNormal)
-			canvas.Text((width - xMarginRightIndent), b.getY(), "$"+charge.ChargeValue, labelGenericTextNormalBoldAnchorEnd)
+			rows = append(rows, textRow(spaceLine, indentFeeLine, charge.ChargeName, labelGenericTextNormal).
+				withValue(alignRightIndent, span("$"+strings.TrimPrefix(charge.ChargeValue, "$"), labelGenericTextNormalBoldAnchorEnd)))
 		}
 	} else {
-		canvas.Text(xFeeLine, b.addY(17), "No additional monthly fees", labelGenericTextNormal)
+		rows = append(rows, textRow(spaceLine, indentFeeLine, texts.text("no_monthly_fees", template), labelGenericTextNormal))
 	}
 
-	canvas.Text(xParagraph, b.addY(35), "One-time Fees at the Time of Purchase", labelGenericTextNormal)
+	rows = append(rows, textRow(spaceGroup, indentParagraph, texts.text("one_time_fees", template), labelGenericTextNormal))
 	if len(template.ExtraOneTimeFields) > 0 {
 		for _, charge := range template.ExtraOneTimeFields {
-			charge.ChargeValue = strings.TrimPrefix(charge.ChargeValue, "$")
-			canvas.Text(xFeeLine, b.addY(17), charge.ChargeName, labelGenericTextNormal)
-			canvas.Text((width - xMarginRightIndent), b.getY(), "$"+charge.ChargeValue, labelGenericTextNormalBoldAnchorEnd)
+			rows = append(rows, textRow(spaceLine, indentFeeLine, charge.ChargeName, labelGenericTextNormal).
+				withValue(alignRightIndent, span("$"+strings.TrimPrefix(charge.ChargeValue, "$"), labelGenericTextNormalBoldAnchorEnd)))
 		}
 	} else {
-		canvas.Text(xFeeLine, b.addY(17), "No additional one-time fees at time of purchase", labelGenericTextNormal)
-
+		rows = append(rows, textRow(spaceLine, indentFeeLine, texts.text("no_one_time_fees", template), labelGenericTextNormal))
 	}
 
+	earlyTerminationFee := span(texts.text("none", template), labelGenericTextNormalHeavyBoldAnchorEnd)
 	if template.EarlyTerminationFee != "" {
-		template.EarlyTerminationFee = strings.TrimPrefix(template.EarlyTerminationFee, "$")
-		canvas.Text(xParagraph, b.addY(35), "Early Termination Fee", labelGenericTextNormal)
-		canvas.Text((width - xMarginRightIndent), b.getY(), "$"+template.EarlyTerminationFee, labelGenericTextNormalBoldAnchorEnd)
-	} else {
-		canvas.Text(xParagraph, b.addY(35), "Early Termination Fee", labelGenericTextNormal)
-		canvas.Text((width - xMarginRightIndent), b.getY(), "None", labelGenericTextNormalHeavyBoldAnchorEnd)
+		earlyTerminationFee = span("$"+strings.TrimPrefix(template.EarlyTerminationFee, "$"), labelGenericTextNormalBoldAnchorEnd)
 	}
-	canvas.Text(xParagraph, b.addY(35), "Government Taxes", labelGenericTextNormal)
-	canvas.Text((width - xMargin), b.getY(), "Varies by Location", labelGenericTextNormalHeavyBoldAnchorEnd)
-	canvas.Line(xMargin, b.addY(10), width-xMargin, b.getY(), "stroke:black;stroke-width:3")
-	canvas.Gend()
+
+	return append(rows,
+		textRow(spaceGroup, indentParagraph, texts.text("early_termination_fee", template), labelGenericTextNormal).
+			withValue(alignRightIndent, earlyTerminationFee),
+		textRow(spaceGroup, indentParagraph, texts.text("government_taxes", template), labelGenericTextNormal).
+			withValue(alignRight, span(texts.text("varies_by_location", template), labelGenericTextNormalHeavyBoldAnchorEnd)),
+		ruleRow(spaceRule, 3),
+	), texts.err
 }
 
-func (b *BroadbandConsumerLabel) discountsAndBundles(canvas *svg.SVG, thisSectionYStart int, template BroadbandData, fontList string) {
-	canvas.Gstyle("")
-	canvas.Text(xMargin, b.addY(23), "Discounts & Bundles", labelSectionHeading)
-	canvas.Textspan(xParagraph, b.addY(17), "", labelGenericTextNormal)
-	canvas.Link(template.DiscountsAndBundlesURL, "Click Here")
-	canvas.Span("Click here", "fill:blue")
-	canvas.LinkEnd()
-	canvas.TextEnd()
-	canvas.Text(109, b.getY(), "for available billing discounts and pricing", labelGenericTextNormal)
-	canvas.Text(xParagraph, b.addY(17), "options for broadband service bundled with other", labelGenericTextNormal)
-	canvas.Text(xParagraph, b.addY(17), "services like video, phone, and wireless service", labelGenericTextNormal)
-	canvas.Text(xParagraph, b.addY(17), "and use of your own equipment like modems and", labelGenericTextNormal)
-	canvas.Text(xParagraph, b.addY(17), "routers.", labelGenericTextNormal)
-	canvas.Line(xMargin, b.addY(10), width-xMargin, b.getY(), "stroke:black;stroke-width:1")
-	canvas.Gend()
+func discountsAndBundles(template LabelTemplateData) ([]layoutRow, error) {
+	texts := &labelTexts{}
+	return []layoutRow{
+		textRow(spaceHeading, indentMargin, texts.text("discounts_and_bundles", template), labelSectionHeading),
+		paragraphRow(spaceLine, indentParagraph,
+			linkSpan(texts.text("discounts_link", template), template.DiscountsAndBundlesURL, labelGenericTextNormal),
+			span(" "+texts.text("discounts_text", template), labelGenericTextNormal)),
+		ruleRow(spaceRule, 1),
+	}, texts.err
 }
 
-func (b *BroadbandConsumerLabel) participatesInACP(canvas *svg.SVG, thisSectionYStart int, template BroadbandData, fontList string) {
-	canvas.Gstyle("")
-	canvas.Text(xMargin, b.addY(23), "Affordable Connectivity Program (ACP)", labelSectionHeading)
-	canvas.Text(xParagraph, b.addY(17), "The ACP is a government program to help lower the", labelGenericTextNormal)
-	canvas.Text(xParagraph, b.addY(17), "monthly cost of internet service. To learn more", labelGenericTextNormal)
-	canvas.Text(xParagraph, b.addY(17), "about the ACP, including to find out whether you", labelGenericTextNormal)
-	canvas.Text(xParagraph, b.addY(17), "qualify, visit:", labelGenericTextNormal)
-	canvas.Textspan(125, b.getY(), "", labelGenericTextNormal)
-	canvas.Link("https://affordableconnectivity.gov/", "affordableconnectivity.gov")
-	canvas.Span("affordableconnectivity.gov", "fill:blue")
-	canvas.LinkEnd()
-	canvas.TextEnd()
-
-	template.AcpEnabled = strings.ToUpper(template.AcpEnabled)
-	if template.AcpEnabled == "YES" || template.AcpEnabled == "1" || template.AcpEnabled == "TRUE" {
-		canvas.Text(56, b.addY(17), "Participates in the ACP", labelGenericTextNormalBold)
-		canvas.Text((width - xMarginRightIndentHard), b.getY(), "Yes", labelGenericTextNormalHeavyBoldAnchorStart)
-	} else {
-		canvas.Text(56, b.addY(17), "Participates in the ACP", labelGenericTextNormalBold)
-		canvas.Text((width - xMarginRightIndentHard), b.getY(), "No", labelGenericTextNormalHeavyBoldAnchorStart)
+func participatesInACP(template LabelTemplateData) ([]layoutRow, error) {
+	texts := &labelTexts{}
+	participates := texts.text("no", template)
+	acpEnabled := strings.ToUpper(template.AcpEnabled)
+	if acpEnabled == "YES" || acpEnabled == "1" || acpEnabled == "TRUE" {
+		participates = texts.text("yes", template)
 	}
-	canvas.Line(xMargin, b.addY(10), width-xMargin, b.getY(), "stroke:black;stroke-width:3")
-	canvas.Gend()
-}
 
-func (b *BroadbandConsumerLabel) planSpeeds(canvas *svg.SVG, thisSectionYStart int, template BroadbandData, fontList string) {
-	canvas.Gstyle("")
-	canvas.Text(xMargin, b.addY(23), "Speeds Provided with Plan", labelSectionHeading)
-	canvas.Text(xIndent, b.addY(17), "Typical Download Speed", labelGenericTextNormal)
-	canvas.Text((width - xMarginRightIndentHard), b.getY(), template.CalculatedDLSpeedInMbps+" Mbps", labelGenericTextNormalHeavyBoldAnchorStart)
-	canvas.Text(xIndent, b.addY(17), "Typical Upload Speed", labelGenericTextNormal)
-	canvas.Text((width - xMarginRightIndentHard), b.getY(), template.CalculatedULSpeedInMbps+" Mbps", labelGenericTextNormalHeavyBoldAnchorStart)
-	canvas.Text(xIndent, b.addY(17), "Typical Latency", labelGenericTextNormal)
-	canvas.Text((width - xMarginRightIndentHard), b.getY(), template.LatencyInMs+" ms", labelGenericTextNormalHeavyBoldAnchorStart)
-	canvas.Line(xMargin, b.addY(10), width-xMargin, b.getY(), "stroke:black;stroke-width:1")
-	canvas.Gend()
+	return []layoutRow{
+		textRow(spaceHeading, indentMargin, texts.text("acp", template), labelSectionHeading),
+		paragraphRow(spaceLine, indentParagraph,
+			span(texts.text("acp_text", template)+" ", labelGenericTextNormal),
+			linkSpan(texts.text("acp_link", template), "https://affordableconnectivity.gov/", labelGenericTextNormal)),
+		textRow(spaceLine, indentFeeLine, texts.text("acp_participates", template), labelGenericTextNormalBold).
+			withValue(alignValueColumn, span(participates, labelGenericTextNormalHeavyBoldAnchorStart)),
+		ruleRow(spaceRule, 3),
+	}, texts.err
+}
 
+func planSpeeds(template LabelTemplateData) ([]layoutRow, error) {
+	texts := &labelTexts{}
+	return []layoutRow{
+		textRow(spaceHeading, indentMargin, texts.text("speeds", template), labelSectionHeading),
+		textRow(spaceLine, indentSubItem, texts.text("download_speed", template), labelGenericTextNormal).
+			withValue(alignValueColumn, span(template.CalculatedDLSpeedInMbps+" Mbps", labelGenericTextNormalHeavyBoldAnchorStart)),
+		textRow(spaceLine, indentSubItem, texts.text("upload_speed", template), labelGenericTextNormal).
+			withValue(alignValueColumn, span(template.CalculatedULSpeedInMbps+" Mbps", labelGenericTextNormalHeavyBoldAnchorStart)),
+		textRow(spaceLine, indentSubItem, texts.text("latency", template), labelGenericTextNormal).
+			withValue(alignValueColumn, span(template.LatencyInMs+" ms", labelGenericTextNormalHeavyBoldAnchorStart)),
+		ruleRow(spaceRule, 1),
+	}, texts.err
 }
 
-func (b *BroadbandConsumerLabel) dataIncluded(canvas *svg.SVG, thisSectionYStart int, template BroadbandData, fontList string) {
-	canvas.Gstyle("")
+func dataIncluded(template LabelTemplateData) ([]layoutRow, error) {
+	texts := &labelTexts{}
+	dataIncluded := texts.text("unlimited", template)
+	overage := texts.text("none", template)
 	if template.DataIncludedInMonthlyPriceGB != "" {
-		canvas.Text(xMargin, b.addY(23), "Data Included with Monthly Price", labelSectionHeading)
-		canvas.Text((width - xMarginRightIndentHard), b.getY(), template.DataIncludedInMonthlyPriceGB+" GB", labelGenericTextNormalHeavyBoldAnchorStart)
-		canvas.Text(xIndent, b.addY(17), "Charges for Additional Data Usage", labelGenericTextNormal)
-		if template.OverageFee == "" {
-			canvas.Text((width - xMarginRightIndentHard), b.getY(), "None", labelGenericTextNormalHeavyBoldAnchorStart)
-		} else {
-			template.OverageFee = strings.TrimPrefix(template.OverageFee, "$")
-			canvas.Text((width - xMarginRightIndentHard), b.getY(), "$"+template.OverageFee+"/"+template.OverageDataAmount+"GB", labelGenericTextNormalHeavyBoldAnchorStart)
+		dataIncluded = template.DataIncludedInMonthlyPriceGB + " GB"
+		if template.OverageFee != "" {
+			overage = "$" + strings.TrimPrefix(template.OverageFee, "$") + "/" + template.OverageDataAmount + "GB"
 		}
-	} else {
-		canvas.Text(xMargin, b.addY(23), "Data Included with Monthly Price", labelSectionHeading)
-		canvas.Text((width - xMarginRightIndentHard), b.getY(), "Unlimited", labelGenericTextNormalHeavyBoldAnchorStart)
-		canvas.Text(xIndent, b.addY(17), "Charges for Additional Data Usage", labelGenericTextNormal)
-		canvas.Text((width - xMarginRightIndentHard), b.getY(), "None", labelGenericTextNormalHeavyBoldAnchorStart)
 	}
-	canvas.Line(xMargin, b.addY(10), width-xMargin, b.getY(), "stroke:black;stroke-width:3")
-	canvas.Gend()
+
+	return []layoutRow{
+		textRow(spaceHeading, indentMargin, texts.text("data_included", template), labelSectionHeading).
+			withValue(alignValueColumn, span(dataIncluded, labelGenericTextNormalHeavyBoldAnchorStart)),
+		textRow(spaceLine, indentSubItem, texts.text("additional_data_charges", template), labelGenericTextNormal).
+			withValue(alignValueColumn, span(overage, labelGenericTextNormalHeavyBoldAnchorStart)),
+		ruleRow(spaceRule, 3),
+	}, texts.err
 }
 
-func (b *BroadbandConsumerLabel) policies(canvas *svg.SVG, thisSectionYStart int, template BroadbandData, fontList string) {
-	canvas.Gstyle("")
-	canvas.Text(xMargin, b.addY(23), "Network Management", labelSectionHeading)
-	canvas.Textspan(width-xMargin, b.getY(), "", labelGenericTextNormalBoldAnchorEnd)
-	canvas.Link(template.NetworkManagementURL, template.NetworkManagementURL)
-	canvas.Span("Read our Policy", "fill:blue")
-	canvas.LinkEnd()
-	canvas.TextEnd()
-
-	canvas.Text(xMargin, b.addY(17), "Privacy", labelSectionHeading)
-	canvas.Textspan(width-xMargin, b.getY(), "", labelGenericTextNormalBoldAnchorEnd)
-	canvas.Link(template.PrivacyPolicyURL, template.PrivacyPolicyURL)
-	canvas.Span("Read our Policy", "fill:blue")
-	canvas.LinkEnd()
-	canvas.TextEnd()
-	canvas.Line(xMargin, b.addY(15), width-xMargin, b.getY(), "stroke:black;stroke-width:12")
-	canvas.Gend()
+func policies(template LabelTemplateData) ([]layoutRow, error) {
+	texts := &labelTexts{}
+	return []layoutRow{
+		textRow(spaceHeading, indentMargin, texts.text("network_management", template), labelSectionHeading).
+			withValue(alignRight, linkSpan(texts.text("read_policy", template), template.NetworkManagementURL, labelGenericTextNormalBoldAnchorEnd)),
+		textRow(spaceLine, indentMargin, texts.text("privacy", template), labelSectionHeading).
+			withValue(alignRight, linkSpan(texts.text("read_policy", template), template.PrivacyPolicyURL, labelGenericTextNormalBoldAnchorEnd)),
+		ruleRow(spaceRuleWide, 12),
+	}, texts.err
 }
 
-func (b *BroadbandConsumerLabel) customerSupport(canvas *svg.SVG, thisSectionYStart int, template BroadbandData, fontList string) {
-	canvas.Gstyle("")
-	canvas.Text(xMargin, b.addY(25), "Customer Support", labelSectionHeading)
-
-	canvas.Text(xIndent, b.addY(17), "Contact Us:", labelGenericTextNormal)
-	canvas.Textspan(width-310, b.getY(), "", labelGenericTextNormal)
-	canvas.Link(template.CustomerSupportURL, template.CustomerSupportURL)
-	canvas.Span("Contact Us", "fill:blue")
-	canvas.LinkEnd()
-	canvas.TextEnd()
-	canvas.Text(width-225, b.getY(), "/ "+template.CustomerSupportPhone, labelGenericTextNormal)
-	canvas.Line(xMargin, b.addY(15), width-xMargin, b.getY(), "stroke:black;stroke-width:6")
-	canvas.Gend()
+func customerSupport(template LabelTemplateData) ([]layoutRow, error) {
+	texts := &labelTexts{}
+	return []layoutRow{
+		textRow(spaceHeadingLarge, indentMargin, texts.text("customer_support", template), labelSectionHeading),
+		flowRow(spaceLine, indentSubItem,
+			span(texts.text("contact_us", template)+" ", labelGenericTextNormal),
+			linkSpan(texts.text("contact_us_link", template), template.CustomerSupportURL, labelGenericTextNormal),
+			span(" / "+template.CustomerSupportPhone, labelGenericTextNormal)),
+		ruleRow(spaceRuleWide, 6),
+	}, texts.err
 }
 
-func (b *BroadbandConsumerLabel) fccLabelTerms(canvas *svg.SVG, thisSectionYStart int, template BroadbandData, fontList string) {
-	canvas.Gstyle("")
-	canvas.Text(xMargin, b.addY(17), "Learn more about the terms used on this label by", labelGenericTextNormal)
-	canvas.Text(xMargin, b.addY(17), "visiting the Federal Communications Commission's", labelGenericTextNormal)
-	canvas.Text(xMargin, b.addY(17), "Consumer Resource Center.", labelGenericTextNormal)
-
-	canvas.Textspan(width-xMargin, b.addY(17), "", labelFccLink)
-	canvas.Link("https://fcc.gov/consumer", "https://fcc.gov/consumer")
-	canvas.Span("fcc.gov/consumer", "fill:blue")
-	canvas.LinkEnd()
-	canvas.TextEnd()
-	canvas.Gend()
+func fccLabelTerms(template LabelTemplateData) ([]layoutRow, error) {
+	texts := &labelTexts{}
+	return []layoutRow{
+		paragraphRow(spaceLine, indentMargin,
+			span(texts.text("fcc_terms", template), labelGenericTextNormal)),
+		textRow(spaceLine, indentMargin, "", labelGenericTextNormal).
+			withValue(alignRight, linkSpan(texts.text("fcc_link", template), "https://fcc.gov/consumer", labelFccLink)),
+	}, texts.err
 }
 
-func (b *BroadbandConsumerLabel) uniquePlanIdentifier(canvas *svg.SVG, thisSectionYStart int, template BroadbandData, fontList string) {
-	canvas.Gstyle("")
-	FixedMobile := ""
-	if template.FixedOrMobile == "Fixed" {
-		FixedMobile = "F"
-	} else {
-		FixedMobile = "M"
+func uniquePlanIdentifier(template LabelTemplateData) ([]layoutRow, error) {
+	var rows []layoutRow
+	if qrEnabled && template.PlanURL != "" {
+		qr, err := encodeQRCode(template.PlanURL)
+		if err != nil {
+			return nil, err
+		}
+		rows = append(rows, boxRow(layoutBox{offset: 4, width: qrCodeSize, height: qrCodeSize, qr: qr, link: template.PlanURL}))
 	}
-	serviceId := strings.Repeat("0", 15-len(template.DataServiceID)) + template.DataServiceID
-	uniquePlanIdentifier := FixedMobile + template.FccID + serviceId
 
-	canvas.Text(xMargin, b.addY(17), uniquePlanIdentifier, labelUniquePlanId)
-	canvas.Line(xMargin, b.addY(15), width-xMargin, b.getY(), "stroke:white;stroke-width:6")
-	canvas.Gend()
+	return append(rows,
+		textRow(spaceLine, indentMargin, template.PlanID, labelUniquePlanId),
+		ruleRow(spaceRuleWide, 6).withRuleStyle("stroke:white;stroke-width:6"),
+	), nil
 }
 
-var svgStartTag = `
-<!-- coded by andy, katherine and gene @ sonar.software -->
-<!-- https://www.sonar.software -->
-
-<svg
-     id="bcd"
-     viewBox="{{ .TemplateViewBox }}"
-     xmlns="http://www.w3.org/2000/svg"
-     xmlns:xlink="http://www.w3.org/1999/xlink">`
-
-func generateLabels(templateData []BroadbandData) error {
-
-	for templateNumber, template := range templateData {
-		templateFile, err := os.Create(fmt.Sprintf("%v/label_%d.svg", outputDirectory, templateNumber))
-		if err != nil {
-			log.Fatalln("error:", err)
-			return err
+// renderSVG draws the result of the layout pass, each section in its own group.
+func renderSVG(canvas *svg.SVG, sections [][]placedElement) {
+	for _, section := range sections {
+		canvas.Gstyle("")
+		for _, element := range section {
+			switch element.kind {
+			case placedText:
+				if element.link != "" {
+					canvas.Link(element.link, element.text)
+					canvas.Text(element.x, element.y, element.text, element.style+";"+linkStyle())
+					canvas.LinkEnd()
+				} else {
+					canvas.Text(element.x, element.y, element.text, element.style)
+				}
+			case placedLine:
+				canvas.Line(element.x, element.y, element.x2, element.y2, element.style)
+			case placedImage:
+				canvas.Image(element.x, element.y, element.width, element.height, element.href, `preserveAspectRatio="xMaxYMin meet"`)
+			case placedQRCode:
+				drawQRCode(canvas, element.x, element.y, element.width, element.qr, element.link)
+			}
 		}
-		defer templateFile.Close()
-
-		templateWriter := &TemplateWriter{}
-
-		canvas := svg.New(templateWriter)
-		fmt.Fprintln(templateWriter, svgStartTag)
-
-		setTemplateStyles(canvas)
-		fontList := "Roboto"
-
-		var label BroadbandConsumerLabel
-
-		canvas.Gid("content-group")
-		fmt.Fprintln(templateWriter, `<rect width="431" height="942" style="fill:white" />`)
-		fmt.Fprintln(templateWriter, `<rect x="4.5" y="7.5" width="419" height="{{ .CalcYRectHeight }}" style="fill:none;stroke:black;stroke-width:3" />`)
-
-		label.labelTitle(canvas, 0)
-		label.providerBlock(canvas, 0, template, fontList)
-		label.monthlyPrice(canvas, 0, template, fontList)
-		label.monthlyDetails(canvas, 0, template, fontList)
-		label.additionalChargesAndTerms(canvas, 0, template, fontList)
-		label.discountsAndBundles(canvas, 0, template, fontList)
-		label.participatesInACP(canvas, 0, template, fontList)
-		label.planSpeeds(canvas, 0, template, fontList)
-		label.dataIncluded(canvas, 0, template, fontList)
-		label.policies(canvas, 0, template, fontList)
-		label.customerSupport(canvas, 0, template, fontList)
-		label.fccLabelTerms(canvas, 0, template, fontList)
-		label.uniquePlanIdentifier(canvas, 0, template, fontList)
 		canvas.Gend()
-		canvas.End()
+	}
+}
 
-		// bake the viewbox
+// generateLabel writes the label of a plan to the group directory. A label
+// unchanged since the previous run is taken from cache instead of being
+// rendered again.
+func generateLabel(group *labelGroup, fileName string, template BroadbandData, cache *labelCache) error {
+	label := group.labelPath(fileName)
+	fileName = filepath.Join(group.directory, fileName)
 
-		templateWriter.ApplyDynamicCalculations(label.getY())
+	hash, err := cache.labelHash(template)
+	if err != nil {
+		return err
+	}
+	if cache.reuse(label, hash, fileName) {
+		cache.record(label, hash, true)
+		return nil
+	}
+	cache.record(label, hash, false)
 
-		// write the contents of templateWriter to the templateFile
-		for _, v := range templateWriter.bcdTemplate {
-			fmt.Fprintln(templateFile, v)
-		}
+	templateFile, err := os.Create(fileName)
+	if err != nil {
+		return err
+	}
 
+	writer := bufio.NewWriter(templateFile)
+	err = renderLabel(writer, template)
+	if err == nil {
+		err = writer.Flush()
+	}
+	if err != nil {
+		templateFile.Close()
+		return err
 	}
-	return nil
+	return templateFile.Close()
 }
 
-// TemplateWriter is a custom io.Writer that appends data to a []string.
-type TemplateWriter struct {
-	bcdTemplate []string
-}
+// renderLabel writes the svg label of a plan.
+func renderLabel(w io.Writer, template BroadbandData) error {
+	data := newLabelTemplateData(template)
+	sections, height, err := labelLayoutConfig.place(data)
+	if err != nil {
+		return err
+	}
 
-func (w *TemplateWriter) Write(p []byte) (n int, err error) {
-	w.bcdTemplate = append(w.bcdTemplate, string(p))
-	return len(p), nil
-}
+	// the sections are drawn first, the label template then wraps them in
+	// the svg document once the size is known
+	var content strings.Builder
+	canvas := svg.New(&content)
+	renderSVG(canvas, sections)
 
-func (w *TemplateWriter) ApplyDynamicCalculations(y int) {
-	for i, v := range w.bcdTemplate {
-		if strings.Contains(v, "{{ .TemplateViewBox }}") {
-			viewBox := "0 0 431 " + strconv.Itoa(y)
-			w.bcdTemplate[i] = strings.ReplaceAll(v, "{{ .TemplateViewBox }}", viewBox)
-		}
-		if strings.Contains(v, "{{ .CalcYRectHeight }}") {
-			rectHeight := strconv.Itoa(y - 13)
-			w.bcdTemplate[i] = strings.ReplaceAll(v, "{{ .CalcYRectHeight }}", rectHeight)
-		}
-	}
+	data.Width = labelLayoutConfig.width()
+	data.Height = height
+	data.Content = content.String()
 
+	return labelTemplates.ExecuteTemplate(w, "label", data)
 }
@@ -0,0 +1,447 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// columnLayout holds the horizontal positions used by the sections, relative
+// to the left edge of the column they are drawn in.
+type columnLayout struct {
+	width                  int
+	xMargin                int
+	xParagraph             int
+	xFeeLine               int
+	xMarginRightIndent     int
+	xMarginRightIndentHard int
+	xIndent                int
+}
+
+var standardColumn = columnLayout{
+	width:                  431,
+	xMargin:                14,
+	xParagraph:             35,
+	xFeeLine:               55,
+	xMarginRightIndent:     25,
+	xMarginRightIndentHard: 106,
+	xIndent:                29,
+}
+
+// spacing tokens, the distance from the previous baseline to the next one
+const (
+	spaceNone         = 0
+	spaceTight        = 4
+	spaceRuleTight    = 8
+	spaceRule         = 10
+	spaceLineTight    = 14
+	spaceRuleWide     = 15
+	spaceLine         = 17
+	spaceLead         = 20
+	spaceHeading      = 23
+	spaceHeadingLarge = 25
+	spaceHeadline     = 30
+	spaceGroup        = 35
+	spaceTitle        = 55
+)
+
+// indent tokens pick one of the left positions of the column layout
+type layoutIndent int
+
+const (
+	indentMargin layoutIndent = iota
+	indentSubItem
+	indentParagraph
+	indentFeeLine
+)
+
+// value alignments for the right hand side of a row
+type valueAlign int
+
+const (
+	alignRight       valueAlign = iota // end anchored at the right margin
+	alignRightIndent                   // end anchored inside the right margin
+	alignValueColumn                   // start anchored in the value column
+)
+
+type layoutSpan struct {
+	text  string
+	style string
+	link  string
+}
+
+// layoutBox is a fixed size image placed against the right margin. It does
+// not push the rows that follow it down, but the section is at least as tall
+// as the box.
+type layoutBox struct {
+	offset int
+	width  int
+	height int
+	href   string
+	qr     *qrCode
+	link   string
+}
+
+// layoutRow is one line of a section description. A row either flows its
+// spans from an indent, draws a rule across the column, or places a box.
+type layoutRow struct {
+	advance   int
+	indent    layoutIndent
+	spans     []layoutSpan
+	wrap      bool
+	value     *layoutSpan
+	align     valueAlign
+	rule      int
+	ruleStyle string
+	box       *layoutBox
+	// boxWidth is the width kept clear for a box against the right margin
+	// and lineAdvance the advance of wrapped lines, spaceLine when 0
+	boxWidth    int
+	lineAdvance int
+}
+
+func span(text, style string) layoutSpan {
+	return layoutSpan{text: text, style: style}
+}
+
+func linkSpan(text, link, style string) layoutSpan {
+	return layoutSpan{text: text, style: style, link: link}
+}
+
+func textRow(advance int, indent layoutIndent, text, style string) layoutRow {
+	return layoutRow{advance: advance, indent: indent, spans: []layoutSpan{span(text, style)}}
+}
+
+// flowRow places the spans one after another on a single line.
+func flowRow(advance int, indent layoutIndent, spans ...layoutSpan) layoutRow {
+	return layoutRow{advance: advance, indent: indent, spans: spans}
+}
+
+// paragraphRow flows the spans and wraps them inside the right indent, each
+// wrapped line is spaceLine below the previous one.
+func paragraphRow(advance int, indent layoutIndent, spans ...layoutSpan) layoutRow {
+	return layoutRow{advance: advance, indent: indent, spans: spans, wrap: true}
+}
+
+func ruleRow(advance, strokeWidth int) layoutRow {
+	return layoutRow{advance: advance, rule: strokeWidth}
+}
+
+func boxRow(box layoutBox) layoutRow {
+	return layoutRow{box: &box}
+}
+
+func (r layoutRow) withValue(align valueAlign, value layoutSpan) layoutRow {
+	r.value = &value
+	r.align = align
+	return r
+}
+
+// besideBox wraps the row clear of a box of boxWidth placed against the right
+// margin, each wrapped line lineAdvance below the previous one.
+func (r layoutRow) besideBox(boxWidth, lineAdvance int) layoutRow {
+	r.wrap = true
+	r.boxWidth = boxWidth
+	r.lineAdvance = lineAdvance
+	return r
+}
+
+func (r layoutRow) withRuleStyle(style string) layoutRow {
+	r.ruleStyle = style
+	return r
+}
+
+const (
+	placedText = iota
+	placedLine
+	placedImage
+	placedQRCode
+)
+
+// placedElement is the renderer independent result of the layout pass, every
+// coordinate is absolute.
+type placedElement struct {
+	kind   int
+	x      int
+	y      int
+	x2     int
+	y2     int
+	width  int
+	height int
+	text   string
+	style  string
+	link   string
+	href   string
+	qr     *qrCode
+}
+
+type labelSection func(template LabelTemplateData) ([]layoutRow, error)
+
+// labelLayout places the label sections in one or more side by side columns,
+// each column is laid out top to bottom.
+type labelLayout struct {
+	column    columnLayout
+	columnTop int
+	columns   [][]labelSection
+}
+
+const (
+	layoutVertical   = "vertical"
+	layoutHorizontal = "horizontal"
+)
+
+func newLabelLayout(name string, columnCount int) (labelLayout, error) {
+	layout := labelLayout{column: standardColumn, columnTop: 8}
+
+	title := []labelSection{labelTitleSection, providerBlock}
+	pricing := []labelSection{monthlyPrice, monthlyDetails, additionalChargesAndTerms}
+	programs := []labelSection{discountsAndBundles, participatesInACP}
+	service := []labelSection{planSpeeds, dataIncluded, policies}
+	support := []labelSection{customerSupport, fccLabelTerms, uniquePlanIdentifier}
+
+	switch {
+	case name == layoutVertical:
+		layout.columns = [][]labelSection{concatSections(title, pricing, programs, service, support)}
+	case name == layoutHorizontal && columnCount == 2:
+		layout.columns = [][]labelSection{
+			concatSections(title, pricing),
+			concatSections(programs, service, support),
+		}
+	case name == layoutHorizontal && columnCount == 3:
+		layout.columns = [][]labelSection{
+			concatSections(title, pricing),
+			concatSections(programs, service),
+			support,
+		}
+	case name == layoutHorizontal:
+		return layout, fmt.Errorf("the horizontal layout supports 2 or 3 columns, got %d", columnCount)
+	default:
+		return layout, fmt.Errorf("unknown layout %q, expected %s or %s", name, layoutVertical, layoutHorizontal)
+	}
+	return layout, nil
+}
+
+func concatSections(groups ...[]labelSection) []labelSection {
+	var sections []labelSection
+	for _, group := range groups {
+		sections = append(sections, group...)
+	}
+	return sections
+}
+
+func (l labelLayout) width() int {
+	return len(l.columns) * l.column.width
+}
+
+// place runs the layout pass for a plan. It returns the placed elements of
+// every section, in drawing order, and the total height of the label.
+func (l labelLayout) place(template LabelTemplateData) ([][]placedElement, int, error) {
+	var placed [][]placedElement
+	height := 0
+	for columnNumber, sections := range l.columns {
+		label := BroadbandConsumerLabel{columnLayout: l.column, xOffset: columnNumber * l.column.width}
+		if columnNumber > 0 {
+			label.yCounter = l.columnTop
+		}
+
+		for _, section := range sections {
+			rows, err := section(template)
+			if err != nil {
+				return nil, 0, err
+			}
+			placed = append(placed, label.placeSection(rows))
+		}
+
+		if label.getY() > height {
+			height = label.getY()
+		}
+	}
+	return placed, height, nil
+}
+
+// BroadbandConsumerLabel is the state of the layout pass for one column.
+type BroadbandConsumerLabel struct {
+	columnLayout
+	xOffset  int
+	yCounter int
+}
+
+func (b *BroadbandConsumerLabel) addY(offset int) int {
+	b.yCounter += offset
+	return b.yCounter
+}
+
+func (b *BroadbandConsumerLabel) getY() int {
+	return b.yCounter
+}
+
+func (b *BroadbandConsumerLabel) indentX(indent layoutIndent) int {
+	switch indent {
+	case indentSubItem:
+		return b.xOffset + b.xIndent
+	case indentParagraph:
+		return b.xOffset + b.xParagraph
+	case indentFeeLine:
+		return b.xOffset + b.xFeeLine
+	default:
+		return b.xOffset + b.xMargin
+	}
+}
+
+func (b *BroadbandConsumerLabel) valueX(align valueAlign) int {
+	switch align {
+	case alignRightIndent:
+		return b.xOffset + b.width - b.xMarginRightIndent
+	case alignValueColumn:
+		return b.xOffset + b.width - b.xMarginRightIndentHard
+	default:
+		return b.xOffset + b.width - b.xMargin
+	}
+}
+
+func (b *BroadbandConsumerLabel) placeSection(rows []layoutRow) []placedElement {
+	var placed []placedElement
+	boxBottom := 0
+
+	for _, row := range rows {
+		switch {
+		case row.box != nil:
+			top := b.getY() + row.box.offset
+			element := placedElement{
+				kind:   placedImage,
+				x:      b.xOffset + b.width - b.xMargin - row.box.width,
+				y:      top,
+				width:  row.box.width,
+				height: row.box.height,
+				href:   row.box.href,
+				link:   row.box.link,
+				qr:     row.box.qr,
+			}
+			if row.box.qr != nil {
+				element.kind = placedQRCode
+			}
+			placed = append(placed, element)
+			if top+row.box.height > boxBottom {
+				boxBottom = top + row.box.height
+			}
+
+		case row.rule > 0:
+			if boxBottom > b.getY() {
+				b.yCounter = boxBottom
+			}
+			style := row.ruleStyle
+			if style == "" {
+				style = ruleStyle(row.rule)
+			}
+			y := b.addY(row.advance)
+			placed = append(placed, placedElement{
+				kind:  placedLine,
+				x:     b.xOffset + b.xMargin,
+				y:     y,
+				x2:    b.xOffset + b.width - b.xMargin,
+				y2:    y,
+				style: style,
+			})
+
+		default:
+			placed = append(placed, b.placeText(row)...)
+		}
+	}
+
+	if boxBottom > b.getY() {
+		b.yCounter = boxBottom
+	}
+	return placed
+}
+
+func (b *BroadbandConsumerLabel) placeText(row layoutRow) []placedElement {
+	var placed []placedElement
+	left := b.indentX(row.indent)
+
+	lines := [][]layoutSpan{nil}
+	if row.wrap {
+		right := b.xOffset + b.width - b.xMarginRightIndent
+		if row.boxWidth > 0 {
+			// a margin between the text and the box
+			right = b.xOffset + b.width - b.xMargin - row.boxWidth - b.xMargin
+		}
+		lines = wrapSpans(row.spans, right-left)
+	} else {
+		lines[0] = row.spans
+	}
+
+	for lineNumber, line := range lines {
+		advance := row.advance
+		if lineNumber > 0 {
+			advance = spaceLine
+			if row.lineAdvance > 0 {
+				advance = row.lineAdvance
+			}
+		}
+		y := b.addY(advance)
+
+		x := left
+		for _, s := range line {
+			if strings.TrimSpace(s.text) != "" {
+				placed = append(placed, placedElement{
+					kind:  placedText,
+					x:     x + measureText(leadingSpace(s.text), s.style),
+					y:     y,
+					text:  strings.TrimSpace(s.text),
+					style: s.style,
+					link:  s.link,
+				})
+			}
+			x += measureText(s.text, s.style)
+		}
+
+		if lineNumber == 0 && row.value != nil {
+			placed = append(placed, placedElement{
+				kind:  placedText,
+				x:     b.valueX(row.align),
+				y:     y,
+				text:  row.value.text,
+				style: row.value.style,
+				link:  row.value.link,
+			})
+		}
+	}
+	return placed
+}
+
+// wrapSpans breaks the spans into lines no wider than maxWidth, splitting
+// them between words.
+func wrapSpans(spans []layoutSpan, maxWidth int) [][]layoutSpan {
+	lines := [][]layoutSpan{nil}
+	lineWidth := 0
+
+	for _, s := range spans {
+		for _, word := range strings.SplitAfter(s.text, " ") {
+			if word == "" {
+				continue
+			}
+			line := lines[len(lines)-1]
+
+			wordWidth := measureText(strings.TrimRight(word, " "), s.style)
+			if lineWidth > 0 && lineWidth+wordWidth > maxWidth {
+				lines = append(lines, nil)
+				line = nil
+				lineWidth = 0
+				word = strings.TrimLeft(word, " ")
+			}
+
+			// keep consecutive words of the same span together
+			if len(line) > 0 && line[len(line)-1].style == s.style && line[len(line)-1].link == s.link {
+				line[len(line)-1].text += word
+			} else {
+				line = append(line, layoutSpan{text: word, style: s.style, link: s.link})
+			}
+			lines[len(lines)-1] = line
+			lineWidth += measureText(word, s.style)
+		}
+	}
+	return lines
+}
+
+func leadingSpace(text string) string {
+	return text[:len(text)-len(strings.TrimLeft(text, " "))]
+}
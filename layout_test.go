@@ -0,0 +1,150 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestWrapSpans(t *testing.T) {
+	spans := []layoutSpan{
+		linkSpan("Click here", "https://www.sonar.software", labelGenericTextNormal),
+		span(" for available billing discounts and pricing options for broadband service bundled with other services", labelGenericTextNormal),
+	}
+
+	lines := wrapSpans(spans, 300)
+	if len(lines) < 2 {
+		t.Fatalf("Expected the spans to wrap, got %d line", len(lines))
+	}
+
+	if lines[0][0].text != "Click here" || lines[0][0].link == "" {
+		t.Errorf("Expected the link to stay a separate span, got %+v", lines[0][0])
+	}
+
+	var words []string
+	for _, line := range lines {
+		lineWidth := 0
+		for _, s := range line {
+			lineWidth += measureText(s.text, s.style)
+			words = append(words, strings.Fields(s.text)...)
+		}
+		if lineWidth > 300+measureText(" ", labelGenericTextNormal) {
+			t.Errorf("Line is %d wide, expected at most 300", lineWidth)
+		}
+		if strings.HasPrefix(line[0].text, " ") {
+			t.Errorf("Wrapped line starts with a space: %q", line[0].text)
+		}
+	}
+
+	expected := strings.Fields(spans[0].text + spans[1].text)
+	if strings.Join(words, " ") != strings.Join(expected, " ") {
+		t.Errorf("Words were lost or reordered while wrapping. Got %v", words)
+	}
+}
+
+func TestMeasureText(t *testing.T) {
+	regular := measureText("Click here", labelGenericTextNormal)
+	if regular < 60 || regular > 75 {
+		t.Errorf("measureText() = %d; want between 60 and 75", regular)
+	}
+
+	if bold := measureText("Click here", labelGenericTextNormalBold); bold <= regular {
+		t.Errorf("Expected bold text to be wider than %d, got %d", regular, bold)
+	}
+
+	if larger := measureText("Click here", labelSectionHeading); larger <= regular {
+		t.Errorf("Expected 14pt text to be wider than %d, got %d", regular, larger)
+	}
+}
+
+// testPlanRow is a valid csv row, as returned by csvRowReader.next, for the
+// tests to change.
+func testPlanRow() map[string]string {
+	return map[string]string{
+		"csvrow":                      "2",
+		"company_name":                "Sonar Software",
+		"discounts_and_bundles_url":   "https://www.sonar.software/discounts",
+		"acp":                         "Yes",
+		"customer_support_url":        "https://www.sonar.software/support",
+		"customer_support_phone":      "555-555-1234",
+		"network_management_url":      "https://www.sonar.software/network",
+		"privacy_policy_url":          "https://www.sonar.software/privacy",
+		"fcc_id":                      "12345",
+		"data_service_id":             "100",
+		"data_service_name":           "MaxSpeed 100",
+		"fixed_or_mobile":             "Fixed",
+		"data_service_price":          "70.00",
+		"billing_frequency_in_months": "1",
+		"contract_url":                "https://www.sonar.software/contract",
+		"dl_speed_in_kbps":            "100000",
+		"ul_speed_in_kbps":            "20000",
+		"latency_in_ms":               "25",
+	}
+}
+
+// useTestLayout renders the labels of a test with the vertical layout.
+func useTestLayout(t *testing.T) {
+	t.Helper()
+	previous := labelLayoutConfig
+	t.Cleanup(func() { labelLayoutConfig = previous })
+	var err error
+	if labelLayoutConfig, err = newLabelLayout(layoutVertical, 3); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// renderTestLabel renders the label of a csv row with the vertical layout.
+func renderTestLabel(t *testing.T, data map[string]string) (string, error) {
+	t.Helper()
+	useTestLayout(t)
+
+	plan, err := newBroadbandData(data)
+	if err != nil {
+		t.Fatalf("the test row is invalid: %v", err)
+	}
+	var label strings.Builder
+	err = renderLabel(&label, plan)
+	return label.String(), err
+}
+
+func TestIntroductoryRateWithoutContract(t *testing.T) {
+	for _, contract := range []string{"", "0", "12"} {
+		data := testPlanRow()
+		data["introductory_period_in_months"] = "6"
+		data["introductory_price_per_month"] = "40.00"
+		data["contract_duration"] = contract
+
+		label, err := renderTestLabel(t, data)
+		if err != nil {
+			t.Fatalf("contract %q: %v", contract, err)
+		}
+		noContract := strings.Contains(label, "does not require a contract")
+		if noContract != (contract != "12") {
+			t.Errorf("contract %q: expected the no contract line only without a contract", contract)
+		}
+	}
+}
+
+func TestLabelBackgroundHeight(t *testing.T) {
+	viewBox := regexp.MustCompile(`viewBox="0 0 (\d+) (\d+)"`)
+	for _, fees := range []int{0, 6} {
+		data := testPlanRow()
+		for i := 1; i <= fees; i++ {
+			data[fmt.Sprintf("monthly_fee_name_%d", i)] = fmt.Sprintf("Fee %d", i)
+			data[fmt.Sprintf("monthly_fee_price_%d", i)] = "5.00"
+		}
+		label, err := renderTestLabel(t, data)
+		if err != nil {
+			t.Fatalf("%d fees: %v", fees, err)
+		}
+		size := viewBox.FindStringSubmatch(label)
+		if size == nil {
+			t.Fatalf("%d fees: the label has no viewBox", fees)
+		}
+		background := fmt.Sprintf(`<rect width="%s" height="%s" style="fill:white" />`, size[1], size[2])
+		if !strings.Contains(label, background) {
+			t.Errorf("%d fees: expected the background to fill the %sx%s label", fees, size[1], size[2])
+		}
+	}
+}
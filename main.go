@@ -1,9 +1,9 @@
 package main
 
 import (
-	"flag"
-	"log"
+	"fmt"
 	"os"
+	"path/filepath"
 	"sort"
 	"strconv"
 	"strings"
@@ -20,7 +20,14 @@ type AdditionalCharges struct {
 	ChargeValue string
 }
 
+type PriceStep struct {
+	StepNumber     int
+	PeriodInMonths int
+	PricePerMonth  string
+}
+
 type BroadbandData struct {
+	CsvRow                       int
 	CompanyName                  string
 	DiscountsAndBundlesURL       string
 	AcpEnabled                   string
@@ -35,9 +42,12 @@ type BroadbandData struct {
 	DataServicePrice             string
 	MonthlyPrice                 string
 	BillingFrequencyInMonths     string
+	BillingPeriodPrice           string
+	BillingPeriodText            string
 	IntroductoryRate             bool
 	IntroductoryPeriodInMonths   string
 	IntroductoryPricePerMonth    string
+	PriceSchedule                []PriceStep
 	ContractDuration             string
 	ContractURL                  string
 	EarlyTerminationFee          string
@@ -56,160 +66,299 @@ type BroadbandData struct {
 var csvFileName string
 var outputDirectory string
 var zipName string
+var qrBaseURL string
+var qrEnabled bool
+var themeFileName string
+var layoutName string
+var layoutColumns int
+var labelLayoutConfig labelLayout
+var templateDirectory string
+var fileNamePattern = defaultFileNamePattern
+var groupBy = groupByNone
+var pruneStale bool
+var labelJobs = 1
+var dryRun bool
 
 func main() {
-	flag.StringVar(&csvFileName, "inputcsv", "bcd.csv", "the name of the csv file to convert")
-	flag.StringVar(&outputDirectory, "outputdir", "./generated-labels", "the name of the directory to output the generated files to")
-	flag.StringVar(&zipName, "zipname", "generated-labels", "the name of the zip file to output the generated files to")
-	flag.Parse()
+	if err := runCLI(os.Args[1:]); err != nil {
+		logError(err)
+		os.Exit(1)
+	}
+}
+
+func generateCommand(args []string) error {
+	var watch bool
+	if err := parseGenerateFlags(args, &watch); err != nil {
+		return err
+	}
+	if watch {
+		return watchGenerate(args)
+	}
+	return generateLabels()
+}
 
-	// set up customer logger
-	logger := log.New(os.Stderr, "", 0)
+func parseGenerateFlags(args []string, watch *bool) error {
+	flags := newCommandFlags("generate", "[options]", "Validates the csv file and writes a label for each of its plans, with the zip files, indexes and manifests.")
+	addInputFlags(flags)
+	addRenderFlags(flags)
+	addNamingFlags(flags)
+	addOutputFlags(flags)
+	addFilterFlags(flags)
+	flags.BoolVar(watch, "watch", false, "keep running and generate the labels again whenever the csv, config or theme file is saved")
+	if err := parseCommandFlags(flags, args); err != nil {
+		return err
+	}
 
-	err := checkCsvRecords()
-	if err != nil {
-		logger.Fatalln(err.Error())
+	if err := setupRendering(); err != nil {
+		return err
 	}
 
+	if labelJobs < 1 {
+		return fmt.Errorf("-jobs must be at least 1, got %d", labelJobs)
+	}
+
+	if planFilter.active() && (pruneStale || dryRun) {
+		return fmt.Errorf("-prune and -dryrun can't be used with the row filters, the labels of rows left out that aren't valid would be stale")
+	}
+
+	_, err := parseFileNamePattern(fileNamePattern)
+	return err
+}
+
+// generateLabels writes the labels of the csv file to the output directory
+// with the settings of the parsed flags.
+func generateLabels() error {
+	if err := checkOutputDirectory(outputDirectory); err != nil {
+		return err
+	}
 	if _, err := os.Stat(outputDirectory); os.IsNotExist(err) {
 		err := os.Mkdir(outputDirectory, 0755)
 		if err != nil {
-			logger.Fatalln(convertErrorToJSON("NA", err.Error()))
-			return
+			return err
 		}
 	}
 
 	if _, err := os.Stat(csvFileName); os.IsNotExist(err) {
-		logger.Fatalln(convertErrorToJSON("NA", err.Error()))
-		return
+		return err
+	}
+
+	inputSHA256, err := fileSHA256(csvFileName)
+	if err != nil {
+		return err
+	}
+	dates, err := currentLabelDates()
+	if err != nil {
+		return err
 	}
 
-	records, err := loadCSV(csvFileName)
+	// everything is written to a stage directory which replaces the output
+	// directory once all labels and zip files have been written
+	stage, err := newOutputStage(outputDirectory)
 	if err != nil {
-		logger.Fatalln(convertErrorToJSON("NA", err.Error()))
-		return
+		return err
 	}
+	// the stage is gone once it has replaced the output directory, otherwise
+	// the run failed and it is removed
+	defer os.RemoveAll(stage)
 
-	var broadbandData []map[string]string
+	counts, err := writeOutput(stage, inputSHA256, dates)
+	if err != nil {
+		return err
+	}
+	if !dryRun {
+		logInfo("labels: %s", counts)
+	}
+	return nil
+}
 
-	header := records[0]
+// newBroadbandData validates a row of the csv file and works out the label
+// values from it.
+func newBroadbandData(data map[string]string) (BroadbandData, error) {
+	row := data["csvrow"]
+	if err := checkCsvRecord(data); err != nil {
+		return BroadbandData{}, err
+	}
 
-	for _, record := range records[1:] {
-		data := make(map[string]string)
-		for i, value := range record {
-			data[header[i]] = value
-		}
-		broadbandData = append(broadbandData, data)
-	}
-
-	var templateData []BroadbandData
-	for _, data := range broadbandData {
-		templateEntry := BroadbandData{
-			CompanyName:                  data["company_name"],
-			DiscountsAndBundlesURL:       data["discounts_and_bundles_url"],
-			AcpEnabled:                   data["acp"],
-			CustomerSupportURL:           data["customer_support_url"],
-			CustomerSupportPhone:         data["customer_support_phone"],
-			NetworkManagementURL:         data["network_management_url"],
-			PrivacyPolicyURL:             data["privacy_policy_url"],
-			FccID:                        data["fcc_id"],
-			DataServiceID:                data["data_service_id"],
-			DataServiceName:              data["data_service_name"],
-			FixedOrMobile:                data["fixed_or_mobile"],
-			DataServicePrice:             data["data_service_price"],
-			BillingFrequencyInMonths:     data["billing_frequency_in_months"],
-			IntroductoryPeriodInMonths:   data["introductory_period_in_months"],
-			IntroductoryPricePerMonth:    data["introductory_price_per_month"],
-			ContractDuration:             data["contract_duration"],
-			ContractURL:                  data["contract_url"],
-			EarlyTerminationFee:          data["early_termination_fee"],
-			DLSpeedInKbps:                data["dl_speed_in_kbps"],
-			ULSpeedInKbps:                data["ul_speed_in_kbps"],
-			LatencyInMs:                  data["latency_in_ms"],
-			DataIncludedInMonthlyPriceGB: data["data_included_in_monthly_price"],
-			OverageFee:                   data["overage_fee"],
-			OverageDataAmount:            data["overage_data_amount"],
-		}
+	csvRow, _ := strconv.Atoi(row)
+	templateEntry := BroadbandData{
+		CsvRow:                       csvRow,
+		CompanyName:                  data["company_name"],
+		DiscountsAndBundlesURL:       data["discounts_and_bundles_url"],
+		AcpEnabled:                   data["acp"],
+		CustomerSupportURL:           data["customer_support_url"],
+		CustomerSupportPhone:         data["customer_support_phone"],
+		NetworkManagementURL:         data["network_management_url"],
+		PrivacyPolicyURL:             data["privacy_policy_url"],
+		FccID:                        data["fcc_id"],
+		DataServiceID:                data["data_service_id"],
+		DataServiceName:              data["data_service_name"],
+		FixedOrMobile:                data["fixed_or_mobile"],
+		DataServicePrice:             data["data_service_price"],
+		BillingFrequencyInMonths:     data["billing_frequency_in_months"],
+		IntroductoryPeriodInMonths:   data["introductory_period_in_months"],
+		IntroductoryPricePerMonth:    data["introductory_price_per_month"],
+		ContractDuration:             data["contract_duration"],
+		ContractURL:                  data["contract_url"],
+		EarlyTerminationFee:          data["early_termination_fee"],
+		DLSpeedInKbps:                data["dl_speed_in_kbps"],
+		ULSpeedInKbps:                data["ul_speed_in_kbps"],
+		LatencyInMs:                  data["latency_in_ms"],
+		DataIncludedInMonthlyPriceGB: data["data_included_in_monthly_price"],
+		OverageFee:                   data["overage_fee"],
+		OverageDataAmount:            data["overage_data_amount"],
+	}
 
-		err := calculateUploadDownloadSpeeds(&templateEntry)
-		if err != nil {
-			logger.Fatalln("NA", convertErrorToJSON(err.Error()))
-			return
-		}
+	err := calculateUploadDownloadSpeeds(&templateEntry)
+	if err != nil {
+		return templateEntry, convertErrorToJSON(row, err.Error())
+	}
 
-		if templateEntry.FixedOrMobile == "" {
-			templateEntry.FixedOrMobile = "Fixed"
-		}
+	templateEntry.PriceSchedule, err = buildPriceSchedule(data)
+	if err != nil {
+		return templateEntry, convertErrorToJSON(row, err.Error())
+	}
 
-		err = calculateMonthlyPrice(&templateEntry)
-		if err != nil {
-			logger.Fatalln("NA", convertErrorToJSON(err.Error()))
-			return
-		}
+	if templateEntry.FixedOrMobile == "" {
+		templateEntry.FixedOrMobile = "Fixed"
+	}
 
-		// TODO: refactor the error code into csv_checker validation function
-		for fieldName, fieldValue := range data {
-			if len(fieldName) > 0 {
-				for extraFieldName, extraFieldPrice := range extraFieldTypes {
-					if strings.Contains(fieldName, extraFieldName) {
-						splitKey := strings.Split(fieldName, "_")
-						indexNumber, err := strconv.Atoi(splitKey[len(splitKey)-1])
-						if err == nil {
-							if fieldValue != "" {
-								indexStr := strconv.Itoa(indexNumber)
-								if _, ok := data[extraFieldPrice+indexStr]; !ok {
-									logger.Fatalln("NA", convertErrorToJSON("error: missing associated field for", fieldName))
-									continue
-								}
-
-								if data[extraFieldPrice+indexStr] == "" {
-									logger.Fatalln("NA", convertErrorToJSON("error: empty value for", fieldName))
-									continue
-								}
-
-								if len(fieldValue) > 42 {
-									fieldValue = fieldValue[:39] + "..."
-								}
-
-								e := AdditionalCharges{
-									FieldNumber: indexNumber,
-									ChargeName:  fieldValue,
-									ChargeValue: data[extraFieldPrice+indexStr],
-								}
-
-								if strings.Contains(extraFieldPrice, "one_time") {
-									templateEntry.ExtraOneTimeFields = append(templateEntry.ExtraOneTimeFields, e)
-									continue
-								}
-								if strings.Contains(extraFieldPrice, "monthly") {
-									templateEntry.ExtraMonthlyFields = append(templateEntry.ExtraMonthlyFields, e)
-									continue
-								}
+	err = calculateMonthlyPrice(&templateEntry)
+	if err != nil {
+		return templateEntry, convertErrorToJSON(row, err.Error())
+	}
+
+	// TODO: refactor the error code into csv_checker validation function
+	for fieldName, fieldValue := range data {
+		if len(fieldName) > 0 {
+			for extraFieldName, extraFieldPrice := range extraFieldTypes {
+				if strings.Contains(fieldName, extraFieldName) {
+					splitKey := strings.Split(fieldName, "_")
+					indexNumber, err := strconv.Atoi(splitKey[len(splitKey)-1])
+					if err == nil {
+						if fieldValue != "" {
+							indexStr := strconv.Itoa(indexNumber)
+							if _, ok := data[extraFieldPrice+indexStr]; !ok {
+								return templateEntry, convertErrorToJSON(row, "error: missing associated field for", fieldName)
+							}
+
+							if data[extraFieldPrice+indexStr] == "" {
+								return templateEntry, convertErrorToJSON(row, "error: empty value for", fieldName)
+							}
+
+							if len(fieldValue) > 42 {
+								fieldValue = fieldValue[:39] + "..."
+							}
+
+							e := AdditionalCharges{
+								FieldNumber: indexNumber,
+								ChargeName:  fieldValue,
+								ChargeValue: data[extraFieldPrice+indexStr],
+							}
+
+							if strings.Contains(extraFieldPrice, "one_time") {
+								templateEntry.ExtraOneTimeFields = append(templateEntry.ExtraOneTimeFields, e)
+								continue
+							}
+							if strings.Contains(extraFieldPrice, "monthly") {
+								templateEntry.ExtraMonthlyFields = append(templateEntry.ExtraMonthlyFields, e)
+								continue
 							}
-						} else {
-							logger.Fatalln("NA", convertErrorToJSON("error converting index number:", err.Error()))
 						}
+					} else {
+						return templateEntry, convertErrorToJSON(row, "error converting index number:", err.Error())
 					}
 				}
 			}
 		}
-		templateData = append(templateData, templateEntry)
 	}
 
-	for _, data := range templateData {
-		sort.Slice(data.ExtraMonthlyFields, func(i, j int) bool {
-			return strings.ToLower(data.ExtraMonthlyFields[i].ChargeName) < strings.ToLower(data.ExtraMonthlyFields[j].ChargeName)
-		})
-		sort.Slice(data.ExtraOneTimeFields, func(i, j int) bool {
-			return strings.ToLower(data.ExtraOneTimeFields[i].ChargeName) < strings.ToLower(data.ExtraOneTimeFields[j].ChargeName)
-		})
+	sort.Slice(templateEntry.ExtraMonthlyFields, func(i, j int) bool {
+		return strings.ToLower(templateEntry.ExtraMonthlyFields[i].ChargeName) < strings.ToLower(templateEntry.ExtraMonthlyFields[j].ChargeName)
+	})
+	sort.Slice(templateEntry.ExtraOneTimeFields, func(i, j int) bool {
+		return strings.ToLower(templateEntry.ExtraOneTimeFields[i].ChargeName) < strings.ToLower(templateEntry.ExtraOneTimeFields[j].ChargeName)
+	})
+	return templateEntry, nil
+}
+
+// writeOutput writes the labels to stage and swaps it in for the output
+// directory. Files owned by an earlier run that this run didn't write are
+// stale, they are kept unless -prune is set. It returns what the run did to the
+// labels.
+func writeOutput(stage, inputSHA256 string, dates labelDates) (labelCounts, error) {
+	previous, err := loadOutputState(outputDirectory)
+	if err != nil {
+		return labelCounts{}, err
+	}
+	cache, err := newLabelCache(outputDirectory, previous)
+	if err != nil {
+		return labelCounts{}, err
+	}
+
+	if err := writeLabelGroups(stage, cache, inputSHA256, dates); err != nil {
+		return labelCounts{}, err
+	}
+
+	written, err := stagedFiles(stage)
+	if err != nil {
+		return labelCounts{}, err
+	}
+	stale := staleFiles(previous, written, outputDirectory)
+
+	if dryRun {
+		for _, file := range stale {
+			fmt.Println(filepath.Join(outputDirectory, filepath.FromSlash(file)))
+		}
+		return labelCounts{}, os.RemoveAll(stage)
+	}
+
+	counts := labelCounts{created: cache.created, updated: cache.updated, unchanged: cache.unchanged}
+	owned := written
+	var prune []string
+	if pruneStale {
+		prune = stale
+		for _, file := range stale {
+			if _, ok := previous.Labels[file]; ok {
+				counts.removed++
+			}
+		}
+	} else {
+		for _, file := range stale {
+			logWarning("stale file %s no longer matches a csv row, use -prune to remove it", filepath.Join(outputDirectory, filepath.FromSlash(file)))
+			if hash, ok := previous.Labels[file]; ok {
+				counts.stale++
+				cache.hashes[file] = hash
+			}
+		}
+		owned = append(owned, stale...)
+	}
+
+	if err := writeOutputState(stage, owned, cache.hashes); err != nil {
+		return labelCounts{}, err
 	}
+	return counts, commitOutputStage(stage, outputDirectory, prune)
+}
 
-	generateLabels(templateData)
-	err = zipUpLabels(outputDirectory, zipName)
+// writeLabelGroups streams the csv file through the label pipeline, then
+// writes the index and zip file of each group.
+func writeLabelGroups(stage string, cache *labelCache, inputSHA256 string, dates labelDates) error {
+	groups, err := generateLabelsFromCSV(csvFileName, stage, commandLabelSettings(), cache)
 	if err != nil {
-		logger.Fatalln("NA", convertErrorToJSON("error zipping up file: ", err.Error()))
-		return
+		return err
 	}
 
+	for _, group := range groups {
+		if groupBy != groupByNone {
+			err = writeGroupIndex(group)
+			if err != nil {
+				return err
+			}
+		}
+
+		err = zipUpLabels(group, newLabelManifest(group, filepath.Base(csvFileName), inputSHA256, dates))
+		if err != nil {
+			return fmt.Errorf("error zipping up file: %v", err)
+		}
+	}
+	return nil
 }
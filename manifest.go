@@ -0,0 +1,164 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime/debug"
+	"strconv"
+	"time"
+)
+
+const manifestFileName = "manifest.json"
+
+// version is set when building a release, eg:
+// go build -ldflags "-X main.version=1.4.0"
+var version = "dev"
+
+// labelManifest describes the contents of a label zip file so each label can
+// be traced back to its plan and checked against its checksum.
+type labelManifest struct {
+	GeneratorVersion string              `json:"generator_version"`
+	InputFile        string              `json:"input_file"`
+	InputSHA256      string              `json:"input_sha256"`
+	GeneratedAt      string              `json:"generated_at"`
+	Files            []labelManifestFile `json:"files"`
+
+	sourceDate time.Time
+	group      *labelGroup
+}
+
+type labelManifestFile struct {
+	FileName    string `json:"file_name"`
+	PlanID      string `json:"unique_plan_identifier"`
+	Company     string `json:"company_name"`
+	ServiceName string `json:"data_service_name"`
+	CsvRow      int    `json:"csv_row"`
+	SHA256      string `json:"sha256"`
+}
+
+func generatorVersion() string {
+	if version != "dev" {
+		return version
+	}
+	if info, ok := debug.ReadBuildInfo(); ok && info.Main.Version != "" && info.Main.Version != "(devel)" {
+		return info.Main.Version
+	}
+	return version
+}
+
+// newLabelManifest describes the labels of a group as written to disk, the
+// files are only listed as the manifest is written. inputFile is the name of
+// the file the labels were generated from and inputSHA256 its checksum.
+func newLabelManifest(group *labelGroup, inputFile, inputSHA256 string, dates labelDates) labelManifest {
+	return labelManifest{
+		GeneratorVersion: generatorVersion(),
+		InputFile:        inputFile,
+		InputSHA256:      inputSHA256,
+		GeneratedAt:      dates.generated.UTC().Format(time.RFC3339),
+		sourceDate:       dates.source,
+		group:            group,
+	}
+}
+
+// writeTo writes the manifest as indented json one file at a time, so its
+// size doesn't depend on the number of labels.
+func (m labelManifest) writeTo(w io.Writer) error {
+	writer := bufio.NewWriter(w)
+	fmt.Fprintf(writer, "{\n  \"generator_version\": %s,\n  \"input_file\": %s,\n  \"input_sha256\": %s,\n  \"generated_at\": %s,\n  \"files\": [",
+		jsonString(m.GeneratorVersion), jsonString(m.InputFile), jsonString(m.InputSHA256), jsonString(m.GeneratedAt))
+
+	for i, label := range m.group.labels {
+		checksum, err := fileSHA256(filepath.Join(m.group.directory, label.fileName))
+		if err != nil {
+			return err
+		}
+		file, err := json.MarshalIndent(labelManifestFile{
+			FileName:    label.fileName,
+			PlanID:      label.planID,
+			Company:     label.company,
+			ServiceName: label.serviceName,
+			CsvRow:      label.csvRow,
+			SHA256:      checksum,
+		}, "    ", "  ")
+		if err != nil {
+			return err
+		}
+
+		if i > 0 {
+			writer.WriteString(",")
+		}
+		writer.WriteString("\n    ")
+		writer.Write(file)
+	}
+
+	if len(m.group.labels) > 0 {
+		writer.WriteString("\n  ")
+	}
+	writer.WriteString("]\n}\n")
+	return writer.Flush()
+}
+
+func jsonString(value string) string {
+	quoted, _ := json.Marshal(value)
+	return string(quoted)
+}
+
+// defaultSourceDate is the source date when SOURCE_DATE_EPOCH isn't set, the
+// earliest time a zip file can hold.
+var defaultSourceDate = time.Date(1980, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+// sourceDate is the time of the zip file entries and the sitemap. It is not
+// when the files were generated but SOURCE_DATE_EPOCH when set, otherwise
+// defaultSourceDate, so it never changes from one run to the next.
+func sourceDate() (time.Time, error) {
+	epoch := os.Getenv("SOURCE_DATE_EPOCH")
+	if epoch == "" {
+		return defaultSourceDate, nil
+	}
+	seconds, err := strconv.ParseInt(epoch, 10, 64)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("SOURCE_DATE_EPOCH must be a unix timestamp, got %q", epoch)
+	}
+	return time.Unix(seconds, 0).UTC(), nil
+}
+
+// labelDates are the times a run records: source is the time of the zip file
+// entries and generated the generation time of the manifest.
+type labelDates struct {
+	source    time.Time
+	generated time.Time
+}
+
+// currentLabelDates gives the dates of a run. SOURCE_DATE_EPOCH pins the
+// generation time as well, so the same input gives byte identical zip files
+// wherever it is generated, otherwise it is the current time.
+func currentLabelDates() (labelDates, error) {
+	date, err := sourceDate()
+	if err != nil {
+		return labelDates{}, err
+	}
+	if os.Getenv("SOURCE_DATE_EPOCH") != "" {
+		return labelDates{source: date, generated: date}, nil
+	}
+	return labelDates{source: date, generated: time.Now().UTC().Truncate(time.Second)}, nil
+}
+
+func fileSHA256(fileName string) (string, error) {
+	file, err := os.Open(fileName)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
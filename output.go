@@ -0,0 +1,169 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// checkOutputDirectory refuses an output directory the run would take over
+// with files that aren't its own: the working directory or one of its parents,
+// or a directory holding the csv, config or theme file. Their files would be
+// carried over into the stage and the directory replaced on every run.
+func checkOutputDirectory(outputDirectory string) error {
+	directory, err := filepath.Abs(outputDirectory)
+	if err != nil {
+		return err
+	}
+	workingDirectory, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+	if isWithin(directory, workingDirectory) {
+		return fmt.Errorf("the output directory %s is the working directory or one of its parents, use a directory of its own", outputDirectory)
+	}
+
+	for _, file := range []string{csvFileName, configFileInUse, themeFileName} {
+		if file == "" {
+			continue
+		}
+		path, err := filepath.Abs(file)
+		if err != nil {
+			return err
+		}
+		if isWithin(directory, path) {
+			return fmt.Errorf("the output directory %s holds the input file %s, use a directory of its own", outputDirectory, file)
+		}
+	}
+	return nil
+}
+
+// isWithin reports whether path is directory or inside it.
+func isWithin(directory, path string) bool {
+	relative, err := filepath.Rel(directory, path)
+	return err == nil && relative != ".." && !strings.HasPrefix(relative, ".."+string(filepath.Separator))
+}
+
+// newOutputStage creates the temporary directory a run writes its files to.
+// It is created next to the output directory so it can be renamed into place.
+func newOutputStage(outputDirectory string) (string, error) {
+	outputDirectory, err := filepath.Abs(outputDirectory)
+	if err != nil {
+		return "", err
+	}
+	stage, err := os.MkdirTemp(filepath.Dir(outputDirectory), "."+filepath.Base(outputDirectory)+"-")
+	if err != nil {
+		return "", err
+	}
+	return stage, os.Chmod(stage, 0755)
+}
+
+// errExchangeUnsupported is returned by exchangeDirectories where paths can't
+// be exchanged in a single rename.
+var errExchangeUnsupported = errors.New("exchanging directories isn't supported")
+
+// commitOutputStage swaps the stage in for the output directory. Files in the
+// output directory that the run didn't write are linked into the stage first,
+// so they are kept, except for the files listed in prune. A run that fails
+// before this point leaves the output directory as it was.
+//
+// The swap is atomic where exchangeDirectories is supported: the output
+// directory always holds either the previous or the new output, and the
+// previous output is left in the stage to be removed. Elsewhere it falls back
+// to two renames, the output directory is moved aside to <stage>-previous and
+// the stage moved in its place, and a run killed between them leaves the
+// previous output in <stage>-previous.
+func commitOutputStage(stage, outputDirectory string, prune []string) error {
+	outputDirectory, err := filepath.Abs(outputDirectory)
+	if err != nil {
+		return err
+	}
+
+	if _, err := os.Stat(outputDirectory); errors.Is(err, fs.ErrNotExist) {
+		return os.Rename(stage, outputDirectory)
+	}
+
+	if err := carryOverFiles(outputDirectory, stage, prune); err != nil {
+		return err
+	}
+
+	err = exchangeDirectories(stage, outputDirectory)
+	if err == nil {
+		return os.RemoveAll(stage)
+	}
+	if err != errExchangeUnsupported {
+		return err
+	}
+
+	previous := stage + "-previous"
+	if err := os.Rename(outputDirectory, previous); err != nil {
+		return err
+	}
+	if err := os.Rename(stage, outputDirectory); err != nil {
+		// put the previous output back rather than leave nothing behind
+		os.Rename(previous, outputDirectory)
+		return err
+	}
+	return os.RemoveAll(previous)
+}
+
+// carryOverFiles links every file in from that is missing in to and isn't
+// listed in skip.
+func carryOverFiles(from, to string, skip []string) error {
+	skipped := make(map[string]bool, len(skip))
+	for _, file := range skip {
+		skipped[file] = true
+	}
+
+	return filepath.WalkDir(from, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() {
+			return err
+		}
+		relative, err := filepath.Rel(from, path)
+		if err != nil {
+			return err
+		}
+		if skipped[filepath.ToSlash(relative)] {
+			return nil
+		}
+
+		target := filepath.Join(to, relative)
+		if _, err := os.Lstat(target); err == nil {
+			return nil
+		}
+		if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			return err
+		}
+		if err := os.Link(path, target); err == nil {
+			return nil
+		}
+		return copyFile(path, target)
+	})
+}
+
+func copyFile(from, to string) error {
+	source, err := os.Open(from)
+	if err != nil {
+		return err
+	}
+	defer source.Close()
+
+	info, err := source.Stat()
+	if err != nil {
+		return err
+	}
+
+	target, err := os.OpenFile(to, os.O_CREATE|os.O_EXCL|os.O_WRONLY, info.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(target, source); err != nil {
+		target.Close()
+		return err
+	}
+	return target.Close()
+}
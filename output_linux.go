@@ -0,0 +1,18 @@
+package main
+
+import (
+	"errors"
+
+	"golang.org/x/sys/unix"
+)
+
+// exchangeDirectories swaps the directories a and b in a single rename, so
+// each path always names one of them. It returns errExchangeUnsupported when
+// the kernel or the file system can't exchange paths.
+func exchangeDirectories(a, b string) error {
+	err := unix.Renameat2(unix.AT_FDCWD, a, unix.AT_FDCWD, b, unix.RENAME_EXCHANGE)
+	if errors.Is(err, unix.ENOSYS) || errors.Is(err, unix.EINVAL) {
+		return errExchangeUnsupported
+	}
+	return err
+}
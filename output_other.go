@@ -0,0 +1,9 @@
+//go:build !linux
+
+package main
+
+// exchangeDirectories swaps the directories a and b in a single rename, which
+// is only done on Linux.
+func exchangeDirectories(a, b string) error {
+	return errExchangeUnsupported
+}
@@ -0,0 +1,356 @@
+package main
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestCommitOutputStage(t *testing.T) {
+	outputDirectory := filepath.Join(t.TempDir(), "labels")
+	if err := os.MkdirAll(filepath.Join(outputDirectory, "notes"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	writeTestFile(t, filepath.Join(outputDirectory, "label.svg"), "old")
+	writeTestFile(t, filepath.Join(outputDirectory, "notes", "readme.txt"), "kept")
+
+	stage, err := newOutputStage(outputDirectory)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeTestFile(t, filepath.Join(stage, "label.svg"), "new")
+
+	if err := commitOutputStage(stage, outputDirectory, nil); err != nil {
+		t.Fatalf("commitOutputStage returned an error: %v", err)
+	}
+
+	for fileName, expected := range map[string]string{"label.svg": "new", "notes/readme.txt": "kept"} {
+		contents, err := os.ReadFile(filepath.Join(outputDirectory, fileName))
+		if err != nil || string(contents) != expected {
+			t.Errorf("%s contains %q (%v), expected %q", fileName, contents, err, expected)
+		}
+	}
+
+	entries, err := os.ReadDir(filepath.Dir(outputDirectory))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("expected only the output directory to be left, found %d entries", len(entries))
+	}
+}
+
+func TestExchangeDirectories(t *testing.T) {
+	directory := t.TempDir()
+	for _, name := range []string{"a", "b"} {
+		if err := os.Mkdir(filepath.Join(directory, name), 0755); err != nil {
+			t.Fatal(err)
+		}
+		writeTestFile(t, filepath.Join(directory, name, "label.svg"), name)
+	}
+
+	err := exchangeDirectories(filepath.Join(directory, "a"), filepath.Join(directory, "b"))
+	if err == errExchangeUnsupported {
+		t.Skip("directories can't be exchanged here")
+	}
+	if err != nil {
+		t.Fatalf("exchangeDirectories returned an error: %v", err)
+	}
+	for name, expected := range map[string]string{"a": "b", "b": "a"} {
+		contents, err := os.ReadFile(filepath.Join(directory, name, "label.svg"))
+		if err != nil || string(contents) != expected {
+			t.Errorf("%s/label.svg contains %q (%v), expected %q", name, contents, err, expected)
+		}
+	}
+}
+
+func writeTestFile(t *testing.T, fileName, contents string) {
+	t.Helper()
+	if err := os.WriteFile(fileName, []byte(contents), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestStaleFiles(t *testing.T) {
+	outputDirectory := t.TempDir()
+	writeTestFile(t, filepath.Join(outputDirectory, "kept.svg"), "")
+	writeTestFile(t, filepath.Join(outputDirectory, "removed.svg"), "")
+
+	previous := outputState{Files: []string{"kept.svg", "removed.svg", "already-deleted.svg", outputStateFileName}}
+	stale := staleFiles(previous, []string{"kept.svg", "new.svg"}, outputDirectory)
+	if len(stale) != 1 || stale[0] != "removed.svg" {
+		t.Errorf("staleFiles = %v, expected [removed.svg]", stale)
+	}
+
+	stage, err := newOutputStage(outputDirectory)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeTestFile(t, filepath.Join(stage, "kept.svg"), "")
+	if err := commitOutputStage(stage, outputDirectory, stale); err != nil {
+		t.Fatalf("commitOutputStage returned an error: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(outputDirectory, "removed.svg")); !os.IsNotExist(err) {
+		t.Errorf("expected removed.svg to be pruned, got %v", err)
+	}
+}
+
+func TestLabelCache(t *testing.T) {
+	outputDirectory := t.TempDir()
+	writeTestFile(t, filepath.Join(outputDirectory, "plan.svg"), "rendered")
+
+	cache, err := newLabelCache(outputDirectory, outputState{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	plan := BroadbandData{CsvRow: 2, CompanyName: "Greystar", DataServicePrice: "74.95"}
+	hash, _ := cache.labelHash(plan)
+
+	moved := plan
+	moved.CsvRow = 9
+	if movedHash, _ := cache.labelHash(moved); movedHash != hash {
+		t.Errorf("moving a row changed its hash")
+	}
+	changed := plan
+	changed.DataServicePrice = "75.95"
+	if changedHash, _ := cache.labelHash(changed); changedHash == hash {
+		t.Errorf("changing the price didn't change the hash")
+	}
+
+	cache.previous = map[string]string{"plan.svg": hash, "other.svg": hash}
+	target := filepath.Join(t.TempDir(), "plan.svg")
+	if !cache.reuse("plan.svg", hash, target) {
+		t.Fatalf("expected the unchanged label to be reused")
+	}
+	if contents, _ := os.ReadFile(target); string(contents) != "rendered" {
+		t.Errorf("reused label contains %q, expected the previous label", contents)
+	}
+	if cache.reuse("other.svg", "different", target) {
+		t.Errorf("expected a changed label not to be reused")
+	}
+
+	cache.record("plan.svg", hash, true)
+	cache.record("other.svg", "different", false)
+	cache.record("new.svg", hash, false)
+	if cache.unchanged != 1 || cache.updated != 1 || cache.created != 1 {
+		t.Errorf("counted %d created, %d updated, %d unchanged, expected 1 of each", cache.created, cache.updated, cache.unchanged)
+	}
+}
+
+func TestLabelPipelineErrorOrder(t *testing.T) {
+	directory := t.TempDir()
+	group := &labelGroup{directory: directory}
+
+	// a directory in place of a label makes writing it fail
+	for _, fileName := range []string{"9.svg", "4.svg"} {
+		if err := os.Mkdir(filepath.Join(directory, fileName), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cache, err := newLabelCache(directory, outputState{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pipeline := startLabelPipeline(cache, 4)
+	for row := 2; row < 12; row++ {
+		plan := BroadbandData{CsvRow: row, FccID: "1", DataServiceID: strconv.Itoa(row)}
+		pipeline.render(labelJob{group: group, fileName: strconv.Itoa(row) + ".svg", plan: plan})
+	}
+
+	err = pipeline.wait()
+	if err == nil || !strings.Contains(err.Error(), `"row":"4"`) {
+		t.Errorf("the pipeline returned %v, expected the error of csv row 4", err)
+	}
+	if cache.created != 10 {
+		t.Errorf("the pipeline rendered %d labels, expected 10", cache.created)
+	}
+}
+
+func TestGenerateLabelsFromCSV(t *testing.T) {
+	stage := t.TempDir()
+	cache, err := newLabelCache(stage, outputState{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	groups, err := generateLabelsFromCSV("bcd.csv", stage, commandLabelSettings(), cache)
+	if err != nil {
+		t.Fatalf("generateLabelsFromCSV returned an error: %v", err)
+	}
+	if len(groups) != 1 || len(groups[0].labels) != 8 {
+		t.Fatalf("expected one group with the 8 plans of bcd.csv, got %d groups", len(groups))
+	}
+	for i, label := range groups[0].labels {
+		if label.csvRow != i+2 {
+			t.Errorf("label %d is csv row %d, expected the labels in csv order", i, label.csvRow)
+		}
+		if _, err := os.Stat(filepath.Join(stage, label.fileName)); err != nil {
+			t.Errorf("label %s wasn't written: %v", label.fileName, err)
+		}
+	}
+
+	// a row failing validation stops the run with its error
+	csvFile := filepath.Join(t.TempDir(), "invalid.csv")
+	contents, err := os.ReadFile("bcd.csv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.SplitAfter(string(contents), "\n")
+	lines[3] = strings.Replace(lines[3], ",99.95,", ",99.9x,", 1)
+	writeTestFile(t, csvFile, strings.Join(lines, ""))
+
+	if _, err := generateLabelsFromCSV(csvFile, t.TempDir(), commandLabelSettings(), cache); err == nil || !strings.Contains(err.Error(), `"row":"4"`) {
+		t.Errorf("generateLabelsFromCSV returned %v, expected the error of csv row 4", err)
+	}
+}
+
+func TestCheckOutputDirectory(t *testing.T) {
+	directory := t.TempDir()
+	previousDirectory, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(directory); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(previousDirectory)
+
+	previousCSV, previousConfig := csvFileName, configFileInUse
+	defer func() { csvFileName, configFileInUse = previousCSV, previousConfig }()
+	csvFileName = filepath.Join("input", "plans.csv")
+	configFileInUse = filepath.Join(directory, "config", "sonarbcd.yaml")
+
+	for outputDirectory, refused := range map[string]bool{
+		".":                 true,
+		"./":                true,
+		"..":                true,
+		directory:           true,
+		"input":             true,
+		"config":            true,
+		"generated-labels":  false,
+		"input/labels":      false,
+		"../other-labels":   false,
+		"./generated-input": false,
+	} {
+		err := checkOutputDirectory(outputDirectory)
+		if refused && err == nil {
+			t.Errorf("expected the output directory %q to be refused", outputDirectory)
+		}
+		if !refused && err != nil {
+			t.Errorf("expected the output directory %q to be allowed, got %v", outputDirectory, err)
+		}
+	}
+}
+
+func TestWriteOutputCounts(t *testing.T) {
+	useTestLayout(t)
+	previousCSV, previousOutput, previousPrune := csvFileName, outputDirectory, pruneStale
+	defer func() { csvFileName, outputDirectory, pruneStale = previousCSV, previousOutput, previousPrune }()
+
+	contents, err := os.ReadFile("bcd.csv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.SplitAfter(strings.TrimRight(string(contents), "\n"), "\n")
+	csvFileName = filepath.Join(t.TempDir(), "plans.csv")
+	outputDirectory = filepath.Join(t.TempDir(), "labels")
+
+	run := func(csvLines []string, prune bool) labelCounts {
+		t.Helper()
+		writeTestFile(t, csvFileName, strings.Join(csvLines, ""))
+		pruneStale = prune
+		stage, err := newOutputStage(outputDirectory)
+		if err != nil {
+			t.Fatal(err)
+		}
+		counts, err := writeOutput(stage, "input-hash", labelDates{source: defaultSourceDate, generated: defaultSourceDate})
+		if err != nil {
+			t.Fatalf("writeOutput returned an error: %v", err)
+		}
+		return counts
+	}
+
+	// the last plan is removed from the csv, its label is stale
+	for _, test := range []struct {
+		lines    []string
+		prune    bool
+		expected labelCounts
+	}{
+		{lines, false, labelCounts{created: 8}},
+		{lines[:len(lines)-1], false, labelCounts{unchanged: 7, stale: 1}},
+		{lines[:len(lines)-1], false, labelCounts{unchanged: 7, stale: 1}},
+		{lines[:len(lines)-1], true, labelCounts{unchanged: 7, removed: 1}},
+		{lines[:len(lines)-1], true, labelCounts{unchanged: 7}},
+	} {
+		if counts := run(test.lines, test.prune); counts != test.expected {
+			t.Errorf("with -prune=%t the run counted %s, expected %s", test.prune, counts, test.expected)
+		}
+	}
+}
+
+func TestWriteOutputWithFilter(t *testing.T) {
+	useTestLayout(t)
+	previousCSV, previousOutput, previousZip, previousFilter := csvFileName, outputDirectory, zipName, planFilter
+	defer func() {
+		csvFileName, outputDirectory, zipName, planFilter = previousCSV, previousOutput, previousZip, previousFilter
+	}()
+	csvFileName, zipName = "bcd.csv", "generated-labels"
+	outputDirectory = filepath.Join(t.TempDir(), "labels")
+
+	run := func(filter rowFilter) labelCounts {
+		t.Helper()
+		planFilter = filter
+		stage, err := newOutputStage(outputDirectory)
+		if err != nil {
+			t.Fatal(err)
+		}
+		counts, err := writeOutput(stage, "input-hash", labelDates{source: defaultSourceDate, generated: defaultSourceDate})
+		if err != nil {
+			t.Fatalf("writeOutput returned an error: %v", err)
+		}
+		return counts
+	}
+
+	run(rowFilter{})
+	// the filtered run only renders the labels of Greystar, the zip file
+	// still holds every label of the output directory
+	if counts := run(rowFilter{companies: []string{"Greystar"}}); counts != (labelCounts{unchanged: 1}) {
+		t.Errorf("the filtered run counted %s, expected 1 unchanged", counts)
+	}
+
+	archive, err := zip.OpenReader(filepath.Join(outputDirectory, "generated-labels.zip"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer archive.Close()
+	labels := 0
+	var manifest labelManifest
+	for _, file := range archive.File {
+		if file.Name != manifestFileName {
+			labels++
+			continue
+		}
+		reader, err := file.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = json.NewDecoder(reader).Decode(&manifest)
+		reader.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if labels != 8 || len(manifest.Files) != 8 {
+		t.Errorf("expected the zip file and manifest to list the 8 labels, got %d labels and %d manifest files", labels, len(manifest.Files))
+	}
+	svgs, err := filepath.Glob(filepath.Join(outputDirectory, "*.svg"))
+	if err != nil || len(svgs) != 8 {
+		t.Errorf("expected the 8 labels in the output directory, got %d (%v)", len(svgs), err)
+	}
+}
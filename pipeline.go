@@ -0,0 +1,174 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+)
+
+// generateLabelsFromCSV reads the csv file one row at a time, validates the
+// rows selected by settings and hands them to labelJobs workers that render
+// and write the label. Reading waits for a free worker, so memory use doesn't
+// grow with the size of the csv file, only the file name and plan details of
+// each label are kept for the group indexes and manifests.
+//
+// The labels of rows left out by the filter are taken from the previous run as
+// they are, so the indexes and zip files list every label of the output
+// directory. A row left out without a label of the previous run, or that isn't
+// valid, has no label.
+func generateLabelsFromCSV(csvFile, stage string, settings labelSettings, cache *labelCache) ([]*labelGroup, error) {
+	reader, err := openFilteredCSV(csvFile, rowFilter{})
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	if err := settings.filter.checkColumns(reader.header); err != nil {
+		return nil, err
+	}
+	for _, warning := range checkCsvHeader(reader.header) {
+		logWarning("%s", warning)
+	}
+
+	groups, err := newLabelGroups(settings.groupBy, stage, settings.fileNamePattern)
+	if err != nil {
+		return nil, err
+	}
+
+	pipeline := startLabelPipeline(cache, labelJobs)
+	for !pipeline.failed() {
+		data, err := reader.next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			pipeline.fail(reader.row+1, err)
+			break
+		}
+		if !settings.filter.matches(data, reader.row) {
+			if err := keepLabel(groups, data, stage, cache); err != nil {
+				pipeline.fail(reader.row, convertErrorToJSON(strconv.Itoa(reader.row), err.Error()))
+				break
+			}
+			continue
+		}
+
+		plan, err := newBroadbandData(data)
+		if err != nil {
+			pipeline.fail(reader.row, err)
+			break
+		}
+
+		group, fileName, err := groups.add(plan)
+		if err == nil && len(group.labels) == 1 {
+			err = os.MkdirAll(group.directory, 0755)
+		}
+		if err != nil {
+			pipeline.fail(reader.row, convertErrorToJSON(strconv.Itoa(reader.row), err.Error()))
+			break
+		}
+
+		pipeline.render(labelJob{group: group, fileName: fileName, plan: plan})
+	}
+
+	if err := pipeline.wait(); err != nil {
+		return nil, err
+	}
+
+	// a company whose rows were all left out without labels has no zip file
+	var written []*labelGroup
+	for _, group := range groups.groups {
+		if len(group.labels) > 0 {
+			written = append(written, group)
+		}
+	}
+	return written, nil
+}
+
+// keepLabel adds the label of a row left out by the filter to its group when
+// the previous run wrote it. The row still takes its file name, so the other
+// labels are named as they are in a run without the filter.
+func keepLabel(groups *labelGroups, data map[string]string, stage string, cache *labelCache) error {
+	plan, err := newBroadbandData(data)
+	if err != nil {
+		// rows left out aren't validated
+		return nil
+	}
+	group, fileName, err := groups.add(plan)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(group.directory, 0755); err != nil {
+		return err
+	}
+	if !cache.keep(group.labelPath(fileName), filepath.Join(group.directory, fileName)) {
+		group.labels = group.labels[:len(group.labels)-1]
+	}
+	return nil
+}
+
+type labelJob struct {
+	group    *labelGroup
+	fileName string
+	plan     BroadbandData
+}
+
+// labelPipeline renders labels with a fixed number of workers. When rows fail
+// the error of the first one in csv order is kept, as every row before a
+// failed row has already been handed to a worker this doesn't depend on the
+// order the workers finish in.
+type labelPipeline struct {
+	cache   *labelCache
+	jobs    chan labelJob
+	workers sync.WaitGroup
+
+	mutex     sync.Mutex
+	failedRow int
+	err       error
+}
+
+func startLabelPipeline(cache *labelCache, workers int) *labelPipeline {
+	p := &labelPipeline{cache: cache, jobs: make(chan labelJob)}
+	for worker := 0; worker < workers; worker++ {
+		p.workers.Add(1)
+		go func() {
+			defer p.workers.Done()
+			for job := range p.jobs {
+				if err := generateLabel(job.group, job.fileName, job.plan, p.cache); err != nil {
+					p.fail(job.plan.CsvRow, convertErrorToJSON(strconv.Itoa(job.plan.CsvRow), err.Error()))
+					continue
+				}
+				logDetail("csv row %d: wrote %s", job.plan.CsvRow, job.group.labelPath(job.fileName))
+			}
+		}()
+	}
+	return p
+}
+
+func (p *labelPipeline) render(job labelJob) {
+	p.jobs <- job
+}
+
+func (p *labelPipeline) fail(row int, err error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if p.err == nil || row < p.failedRow {
+		p.failedRow = row
+		p.err = err
+	}
+}
+
+func (p *labelPipeline) failed() bool {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return p.err != nil
+}
+
+// wait waits for the labels handed to the workers to be written.
+func (p *labelPipeline) wait() error {
+	close(p.jobs)
+	p.workers.Wait()
+	return p.err
+}
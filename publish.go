@@ -0,0 +1,439 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	siteIndexFileName   = "index.html"
+	sitePlanPageName    = "index.html"
+	siteLabelFileName   = "label.svg"
+	sitePlansCSVName    = "plans.csv"
+	sitePlansJSONName   = "plans.json"
+	siteSitemapFileName = "sitemap.xml"
+)
+
+func publishCommand(args []string) error {
+	var siteDirectory, baseURL string
+
+	flags := newCommandFlags("publish", "[options]", "Writes a static website with a page and label for each plan at <baseurl>/<unique plan id>/, an index of the plans by company and fixed or mobile, the plans as csv and json, and a sitemap. The directory can be copied to any web server as it is.")
+	addInputFlags(flags)
+	addRenderFlags(flags)
+	flags.StringVar(&siteDirectory, "sitedir", "./site", "the directory to write the website to")
+	flags.StringVar(&baseURL, "baseurl", "", "the url the website is served from, used for the canonical links and sitemap, defaults to -qrbaseurl")
+	flags.BoolVar(&pruneStale, "prune", false, "remove the pages of plans written by an earlier run that are no longer in the csv")
+	addFilterFlags(flags)
+	if err := parseCommandFlags(flags, args); err != nil {
+		return err
+	}
+
+	if baseURL == "" {
+		baseURL = qrBaseURL
+	}
+	if !strings.HasPrefix(baseURL, "https://") && !strings.HasPrefix(baseURL, "http://") {
+		return fmt.Errorf("-baseurl or -qrbaseurl must be the http or https url the website is served from, got %q", baseURL)
+	}
+	if err := setupRendering(); err != nil {
+		return err
+	}
+
+	plans, err := loadSitePlans(csvFileName, baseURL)
+	if err != nil {
+		return err
+	}
+	date, err := sourceDate()
+	if err != nil {
+		return err
+	}
+
+	if err := checkOutputDirectory(siteDirectory); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(siteDirectory, 0755); err != nil {
+		return err
+	}
+	stage, err := newOutputStage(siteDirectory)
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(stage)
+	if err := publishSite(stage, siteDirectory, baseURL, plans, date); err != nil {
+		return err
+	}
+
+	logInfo("published %d plans to %s", len(plans), siteDirectory)
+	return nil
+}
+
+// sitePlan is a plan of the website with the csv values it was read from,
+// which are published as they are in the csv and json files.
+type sitePlan struct {
+	BroadbandData
+	PlanID string
+	URL    string
+	values map[string]string
+	// selected is false for a plan left out by the row filters, its page
+	// isn't written again
+	selected bool
+}
+
+// loadSitePlans reads every plan of the csv file, including the ones the row
+// filters leave out as the index, data files and sitemap list every plan. Each
+// plan gets a page named after its unique plan identifier, so the identifiers
+// must be unique.
+func loadSitePlans(csvFile, baseURL string) ([]sitePlan, error) {
+	reader, err := openCSV(csvFile)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	reader.filter = rowFilter{}
+
+	var plans []sitePlan
+	rows := make(map[string]int)
+	for {
+		data, err := reader.next()
+		if errors.Is(err, io.EOF) {
+			return plans, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		plan, err := newBroadbandData(data)
+		if err != nil {
+			return nil, err
+		}
+
+		planID := uniquePlanID(plan)
+		if row, ok := rows[planID]; ok {
+			return nil, convertErrorToJSON(data["csvrow"], fmt.Sprintf("the unique plan identifier %s is already used by csv row %d, each plan needs its own page", planID, row))
+		}
+		rows[planID] = plan.CsvRow
+		plans = append(plans, sitePlan{
+			BroadbandData: plan,
+			PlanID:        planID,
+			URL:           planURL(baseURL, planID) + "/",
+			values:        data,
+			selected:      planFilter.matches(data, plan.CsvRow),
+		})
+	}
+}
+
+// publishSite writes the website to stage and swaps it in for the site
+// directory. Pages of plans written by an earlier run are stale once the plan
+// is removed from the csv, they are kept unless -prune is set. The pages of
+// plans left out by the row filters are kept as they are, a plan left out
+// that has no page yet gets one so the index doesn't link to a missing page.
+func publishSite(stage, siteDirectory, baseURL string, plans []sitePlan, sourceDate time.Time) error {
+	previous, err := loadOutputState(siteDirectory)
+	if err != nil {
+		return err
+	}
+
+	kept := make(map[string]bool)
+	for _, plan := range plans {
+		if !plan.selected {
+			page := plan.PlanID + "/" + sitePlanPageName
+			if _, err := os.Stat(filepath.Join(siteDirectory, filepath.FromSlash(page))); err == nil {
+				kept[page] = true
+				kept[plan.PlanID+"/"+siteLabelFileName] = true
+				continue
+			}
+		}
+		if err := writePlanPage(stage, plan); err != nil {
+			return err
+		}
+	}
+	if err := writeSiteFile(stage, siteIndexFileName, func(w io.Writer) error { return writeSiteIndex(w, baseURL, plans) }); err != nil {
+		return err
+	}
+	if err := writeSiteFile(stage, sitePlansCSVName, func(w io.Writer) error { return writeSitePlansCSV(w, plans) }); err != nil {
+		return err
+	}
+	if err := writeSiteFile(stage, sitePlansJSONName, func(w io.Writer) error { return writeSitePlansJSON(w, plans) }); err != nil {
+		return err
+	}
+	if err := writeSiteFile(stage, siteSitemapFileName, func(w io.Writer) error { return writeSitemap(w, baseURL, plans, sourceDate) }); err != nil {
+		return err
+	}
+
+	written, err := stagedFiles(stage)
+	if err != nil {
+		return err
+	}
+	owned := written
+	var stale []string
+	for _, file := range staleFiles(previous, written, siteDirectory) {
+		if kept[file] {
+			owned = append(owned, file)
+		} else {
+			stale = append(stale, file)
+		}
+	}
+
+	var prune []string
+	if pruneStale {
+		prune = stale
+	} else {
+		for _, file := range stale {
+			logWarning("stale file %s no longer matches a csv row, use -prune to remove it", filepath.Join(siteDirectory, filepath.FromSlash(file)))
+		}
+		owned = append(owned, stale...)
+	}
+
+	if err := writeOutputState(stage, owned, nil); err != nil {
+		return err
+	}
+	return commitOutputStage(stage, siteDirectory, prune)
+}
+
+// writeSiteFile writes a file of the website, fileName is relative to the
+// stage and may not leave it.
+func writeSiteFile(stage, fileName string, write func(w io.Writer) error) error {
+	if !filepath.IsLocal(filepath.FromSlash(fileName)) {
+		return fmt.Errorf("the site file %q would be written outside of the site directory", fileName)
+	}
+	fileName = filepath.Join(stage, filepath.FromSlash(fileName))
+	if err := os.MkdirAll(filepath.Dir(fileName), 0755); err != nil {
+		return err
+	}
+	return writeOutputFile(fileName, write)
+}
+
+var sitePlanTemplate = template.Must(template.New("plan").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>Broadband Facts: {{.CompanyName}} {{.DataServiceName}}</title>
+<link rel="canonical" href="{{.URL}}">
+<style>
+body { font-family: sans-serif; margin: 2em; }
+.label { max-width: 431px; }
+.label svg { width: 100%; height: auto; }
+</style>
+</head>
+<body>
+<p><a href="../">All plans</a></p>
+<div class="label">
+{{.Label}}
+</div>
+<p>Unique plan identifier {{.PlanID}}, <a href="label.svg" download="{{.PlanID}}.svg">download the label</a></p>
+</body>
+</html>
+`))
+
+// writePlanPage writes the page of a plan with its label inline, so the text
+// of the label can be read by screen readers and search engines, and the
+// label on its own for downloading.
+func writePlanPage(stage string, plan sitePlan) error {
+	var label bytes.Buffer
+	if err := renderLabel(&label, plan.BroadbandData); err != nil {
+		return err
+	}
+	if err := writeSiteFile(stage, plan.PlanID+"/"+siteLabelFileName, func(w io.Writer) error {
+		_, err := w.Write(label.Bytes())
+		return err
+	}); err != nil {
+		return err
+	}
+
+	return writeSiteFile(stage, plan.PlanID+"/"+sitePlanPageName, func(w io.Writer) error {
+		return sitePlanTemplate.Execute(w, struct {
+			sitePlan
+			Label template.HTML
+		}{plan, template.HTML(label.String())})
+	})
+}
+
+// siteCompany lists the plans of a company by fixed or mobile, the only
+// technology the csv records.
+type siteCompany struct {
+	Name         string
+	Technologies []siteTechnology
+}
+
+type siteTechnology struct {
+	Name  string
+	Plans []sitePlan
+}
+
+// groupSitePlans sorts the companies and their technologies by name, the
+// plans of each keep their csv order.
+func groupSitePlans(plans []sitePlan) []siteCompany {
+	var companies []siteCompany
+	companyIndex := make(map[string]int)
+	for _, plan := range plans {
+		i, ok := companyIndex[plan.CompanyName]
+		if !ok {
+			i = len(companies)
+			companyIndex[plan.CompanyName] = i
+			companies = append(companies, siteCompany{Name: plan.CompanyName})
+		}
+
+		company := &companies[i]
+		j := 0
+		for j < len(company.Technologies) && company.Technologies[j].Name != plan.FixedOrMobile {
+			j++
+		}
+		if j == len(company.Technologies) {
+			company.Technologies = append(company.Technologies, siteTechnology{Name: plan.FixedOrMobile})
+		}
+		company.Technologies[j].Plans = append(company.Technologies[j].Plans, plan)
+	}
+
+	sort.SliceStable(companies, func(i, j int) bool {
+		return strings.ToLower(companies[i].Name) < strings.ToLower(companies[j].Name)
+	})
+	for _, company := range companies {
+		sort.SliceStable(company.Technologies, func(i, j int) bool {
+			return company.Technologies[i].Name < company.Technologies[j].Name
+		})
+	}
+	return companies
+}
+
+var siteIndexTemplate = template.Must(template.New("index").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>Broadband Facts</title>
+<link rel="canonical" href="{{.URL}}">
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; margin-bottom: 1em; }
+th, td { border: 1px solid #ccc; padding: 0.3em 0.6em; text-align: left; }
+</style>
+</head>
+<body>
+<h1>Broadband Facts</h1>
+<p>The broadband labels of {{len .Plans}} plans, also available as <a href="plans.csv">csv</a> and <a href="plans.json">json</a>.</p>
+{{range .Companies}}<section>
+<h2>{{.Name}}</h2>
+{{range .Technologies}}<h3>{{.Name}}</h3>
+<table>
+<tr><th>Plan</th><th>Monthly price</th><th>Download speed</th><th>Upload speed</th></tr>
+{{range .Plans}}<tr><td><a href="{{.PlanID}}/">{{.DataServiceName}}</a></td><td>${{.MonthlyPrice}}</td><td>{{.CalculatedDLSpeedInMbps}} Mbps</td><td>{{.CalculatedULSpeedInMbps}} Mbps</td></tr>
+{{end}}</table>
+{{end}}</section>
+{{end}}</body>
+</html>
+`))
+
+func writeSiteIndex(w io.Writer, baseURL string, plans []sitePlan) error {
+	return siteIndexTemplate.Execute(w, struct {
+		URL       string
+		Plans     []sitePlan
+		Companies []siteCompany
+	}{strings.TrimSuffix(baseURL, "/") + "/", plans, groupSitePlans(plans)})
+}
+
+// sitePlanColumns are the csv columns used by any of the plans, in the order
+// of the csv template, followed by the columns it doesn't know about.
+func sitePlanColumns(plans []sitePlan) []string {
+	used := make(map[string]bool)
+	for _, plan := range plans {
+		for column := range plan.values {
+			if column != "csvrow" && column != "" {
+				used[column] = true
+			}
+		}
+	}
+
+	var columns []string
+	for _, column := range templateColumns() {
+		if used[column] {
+			columns = append(columns, column)
+			delete(used, column)
+		}
+	}
+	var unknown []string
+	for column := range used {
+		unknown = append(unknown, column)
+	}
+	sort.Strings(unknown)
+	return append(columns, unknown...)
+}
+
+// writeSitePlansCSV writes the plans with the csv columns they were read from,
+// after their unique plan identifier and the url of their page.
+func writeSitePlansCSV(w io.Writer, plans []sitePlan) error {
+	columns := sitePlanColumns(plans)
+	writer := csv.NewWriter(w)
+	writer.Write(append([]string{"unique_plan_identifier", "url"}, columns...))
+	for _, plan := range plans {
+		record := []string{plan.PlanID, plan.URL}
+		for _, column := range columns {
+			record = append(record, plan.values[column])
+		}
+		writer.Write(record)
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+// writeSitePlansJSON writes the plans keyed by csv column name, the same shape
+// the api accepts, with their unique plan identifier and url.
+func writeSitePlansJSON(w io.Writer, plans []sitePlan) error {
+	columns := sitePlanColumns(plans)
+	entries := make([]map[string]string, 0, len(plans))
+	for _, plan := range plans {
+		entry := map[string]string{"unique_plan_identifier": plan.PlanID, "url": plan.URL}
+		for _, column := range columns {
+			if value := plan.values[column]; value != "" {
+				entry[column] = value
+			}
+		}
+		entries = append(entries, entry)
+	}
+
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(entries)
+}
+
+type sitemapURL struct {
+	Location     string `xml:"loc"`
+	LastModified string `xml:"lastmod,omitempty"`
+}
+
+// writeSitemap lists the index and the page of each plan, all dated
+// SOURCE_DATE_EPOCH. Without it the pages are left undated rather than
+// carrying defaultSourceDate.
+func writeSitemap(w io.Writer, baseURL string, plans []sitePlan, sourceDate time.Time) error {
+	lastModified := ""
+	if !sourceDate.Equal(defaultSourceDate) {
+		lastModified = sourceDate.Format("2006-01-02")
+	}
+	urls := []sitemapURL{{strings.TrimSuffix(baseURL, "/") + "/", lastModified}}
+	for _, plan := range plans {
+		urls = append(urls, sitemapURL{plan.URL, lastModified})
+	}
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	encoder := xml.NewEncoder(w)
+	encoder.Indent("", "  ")
+	if err := encoder.Encode(struct {
+		XMLName   xml.Name     `xml:"http://www.sitemaps.org/schemas/sitemap/0.9 urlset"`
+		Locations []sitemapURL `xml:"url"`
+	}{Locations: urls}); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
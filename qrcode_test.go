@@ -0,0 +1,92 @@
+package main
+
+import (
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestReedSolomonRemainder(t *testing.T) {
+	// the 1-M "HELLO WORLD" example from ISO/IEC 18004 annex I
+	data := []byte{32, 91, 11, 120, 209, 114, 220, 77, 67, 64, 236, 17, 236, 17, 236, 17}
+	expected := []byte{196, 35, 39, 119, 235, 215, 231, 226, 93, 23}
+
+	result := reedSolomonRemainder(data, reedSolomonDivisor(10))
+	if !reflect.DeepEqual(result, expected) {
+		t.Errorf("reedSolomonRemainder() = %v; want %v", result, expected)
+	}
+}
+
+func TestEncodeQRCodeVersion(t *testing.T) {
+	tests := []struct {
+		length          int
+		expectedVersion int
+		expectedError   bool
+	}{
+		{1, 1, false},
+		{14, 1, false},
+		{15, 2, false},
+		{48, 4, false},
+		{213, 10, false},
+		{214, 0, true},
+	}
+
+	for _, test := range tests {
+		t.Run(strconv.Itoa(test.length), func(t *testing.T) {
+			q, err := encodeQRCode(strings.Repeat("a", test.length))
+
+			if test.expectedError {
+				if err == nil {
+					t.Errorf("Expected an error but got none")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+
+			if q.version != test.expectedVersion {
+				t.Errorf("Version mismatch. Got %d, expected %d", q.version, test.expectedVersion)
+			}
+			if q.size != 17+4*test.expectedVersion || len(q.modules) != q.size {
+				t.Errorf("Size mismatch. Got %d, expected %d", q.size, 17+4*test.expectedVersion)
+			}
+			// the dark module is always set
+			if !q.modules[q.size-8][8] {
+				t.Errorf("Dark module is not set")
+			}
+		})
+	}
+}
+
+func TestRenderLabelQRCode(t *testing.T) {
+	previousURL, previousEnabled := qrBaseURL, qrEnabled
+	defer func() { qrBaseURL, qrEnabled = previousURL, previousEnabled }()
+	qrBaseURL = "https://labels.example.com/bcd/"
+
+	for _, enabled := range []bool{true, false} {
+		qrEnabled = enabled
+		label, err := renderTestLabel(t, testPlanRow())
+		if err != nil {
+			t.Fatalf("qrcode %t: renderLabel returned an error: %v", enabled, err)
+		}
+		link := `xlink:href="https://labels.example.com/bcd/F12345000000000000100"`
+		hasQRCode := strings.Contains(label, link) && strings.Contains(label, "scale(") && strings.Contains(label, "shape-rendering:crispEdges")
+		if hasQRCode != enabled {
+			t.Errorf("qrcode %t: expected the qr code group linking to the plan to be there: %t", enabled, enabled)
+		}
+	}
+}
+
+func TestRenderLabelQRCodeTooLong(t *testing.T) {
+	previousURL, previousEnabled := qrBaseURL, qrEnabled
+	defer func() { qrBaseURL, qrEnabled = previousURL, previousEnabled }()
+	qrBaseURL, qrEnabled = "https://labels.example.com/"+strings.Repeat("a", 300), true
+
+	// the url is too long for the largest qr code, which is an error of the
+	// label rather than the end of the process
+	if _, err := renderTestLabel(t, testPlanRow()); err == nil || !strings.Contains(err.Error(), "too long to encode") {
+		t.Errorf("renderLabel returned %v, expected the qr code error", err)
+	}
+}
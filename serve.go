@@ -0,0 +1,294 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+func serveCommand(args []string) error {
+	server := &previewServer{args: args, reloaded: make(chan struct{})}
+	if err := server.loadSettings(); err != nil {
+		return err
+	}
+
+	go watchFiles(server.watchedFiles, watchPollInterval, server.reload, make(chan struct{}))
+
+	logInfo("serving the labels of %s on http://%s", csvFileName, server.address)
+	httpServer := &http.Server{
+		Addr:              server.address,
+		Handler:           server.handler(),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	return httpServer.ListenAndServe()
+}
+
+// previewServer serves the plans of the csv file and their labels. The
+// settings are read again from the flags and config file whenever a watched
+// file changes, and the open pages are told to reload.
+type previewServer struct {
+	args    []string
+	address string
+
+	// settings guards the package settings, which are replaced on reload
+	// while requests render labels with them
+	settings    sync.RWMutex
+	settingsErr error
+
+	reloadMutex sync.Mutex
+	// reloaded is closed and replaced after each reload, to wake the pages
+	// waiting on it
+	reloaded chan struct{}
+}
+
+func (s *previewServer) parseFlags() error {
+	flags := newCommandFlags("serve", "[options]", "Serves a page listing the plans of the csv file with their labels and validation errors, for checking the labels in a browser. The pages reload when the csv, config or theme file changes.")
+	addInputFlags(flags)
+	addRenderFlags(flags)
+	addNamingFlags(flags)
+	addFilterFlags(flags)
+	flags.StringVar(&s.address, "addr", "localhost:8080", "the address to listen on")
+	if err := parseCommandFlags(flags, s.args); err != nil {
+		return err
+	}
+	return setupRendering()
+}
+
+func (s *previewServer) loadSettings() error {
+	s.settings.Lock()
+	defer s.settings.Unlock()
+	return s.parseFlags()
+}
+
+// reload reads the settings again and tells the open pages to reload. An
+// invalid config file is shown on the plan list until it is fixed.
+func (s *previewServer) reload() {
+	s.settings.Lock()
+	address := s.address
+	s.settingsErr = s.parseFlags()
+	// the server keeps listening on the address it started on
+	s.address = address
+	s.settings.Unlock()
+
+	if s.settingsErr != nil {
+		logError(s.settingsErr)
+	} else {
+		logInfo("reloaded %s", csvFileName)
+	}
+
+	s.reloadMutex.Lock()
+	close(s.reloaded)
+	s.reloaded = make(chan struct{})
+	s.reloadMutex.Unlock()
+}
+
+func (s *previewServer) watchedFiles() []string {
+	s.settings.RLock()
+	defer s.settings.RUnlock()
+
+	return settingsFiles()
+}
+
+func (s *previewServer) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", s.servePlanIndex)
+	mux.HandleFunc("/plans/", s.servePlan)
+	mux.HandleFunc("/labels/", s.serveLabel)
+	mux.HandleFunc("/events", s.serveEvents)
+	return mux
+}
+
+// serveEvents sends a reload event to the page once the settings or csv file
+// have been reloaded.
+func (s *previewServer) serveEvents(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming isn't supported", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	s.reloadMutex.Lock()
+	reloaded := s.reloaded
+	s.reloadMutex.Unlock()
+
+	select {
+	case <-reloaded:
+		fmt.Fprint(w, "data: reload\n\n")
+		flusher.Flush()
+	case <-r.Context().Done():
+	}
+}
+
+// previewRow is a row of the csv file with its plan, or the error that kept
+// it from being a plan.
+type previewRow struct {
+	CsvRow int
+	Plan   BroadbandData
+	PlanID string
+	Error  string
+}
+
+// previewRows reads every row of the csv file, a row failing validation is
+// listed with its error rather than ending the list.
+func previewRows(csvFile string) ([]previewRow, []string, error) {
+	reader, err := openCSV(csvFile)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer reader.Close()
+
+	groups, err := newLabelGroups(groupBy, "", fileNamePattern)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var rows []previewRow
+	for {
+		data, err := reader.next()
+		if errors.Is(err, io.EOF) {
+			return rows, checkCsvHeader(reader.header), nil
+		}
+		if err != nil {
+			// the csv can't be read past a parse error
+			row, message := errorMessage(err)
+			return rows, checkCsvHeader(reader.header), fmt.Errorf("csv row %s: %s", row, message)
+		}
+
+		row := previewRow{CsvRow: reader.row}
+		row.Plan, err = newBroadbandData(data)
+		if err == nil {
+			_, _, err = groups.add(row.Plan)
+		}
+		if err != nil {
+			_, row.Error = errorMessage(err)
+		} else {
+			row.PlanID = uniquePlanID(row.Plan)
+		}
+		rows = append(rows, row)
+	}
+}
+
+const previewReloadScript = `<script>new EventSource("/events").onmessage = function() { location.reload(); };</script>`
+
+var planIndexTemplate = template.Must(template.New("index").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>{{.CsvFile}}</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; }
+th, td { border: 1px solid #ccc; padding: 0.3em 0.6em; text-align: left; }
+.error { color: #cf222e; }
+.warning { color: #9a6700; }
+</style>
+</head>
+<body>
+<h1>{{.CsvFile}}</h1>
+{{if .Error}}<p class="error">{{.Error}}</p>
+{{end}}{{range .Warnings}}<p class="warning">{{.}}</p>
+{{end}}<p>{{.Valid}} of {{len .Rows}} rows are valid</p>
+<table>
+<tr><th>Row</th><th>Company</th><th>Service</th><th>Unique plan identifier</th></tr>
+{{range .Rows}}<tr><td>{{.CsvRow}}</td><td>{{.Plan.CompanyName}}</td><td>{{.Plan.DataServiceName}}</td>
+{{if .Error}}<td class="error">{{.Error}}</td>{{else}}<td><a href="/plans/{{.PlanID}}">{{.PlanID}}</a></td>{{end}}</tr>
+{{end}}</table>
+{{.ReloadScript}}
+</body>
+</html>
+`))
+
+func (s *previewServer) servePlanIndex(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+
+	s.settings.RLock()
+	data := struct {
+		CsvFile      string
+		Error        error
+		Warnings     []string
+		Rows         []previewRow
+		Valid        int
+		ReloadScript template.HTML
+	}{CsvFile: csvFileName, Error: s.settingsErr, ReloadScript: previewReloadScript}
+	if data.Error == nil {
+		data.Rows, data.Warnings, data.Error = previewRows(csvFileName)
+	}
+	s.settings.RUnlock()
+
+	for _, row := range data.Rows {
+		if row.Error == "" {
+			data.Valid++
+		}
+	}
+	writePreviewPage(w, planIndexTemplate, data)
+}
+
+var planPageTemplate = template.Must(template.New("plan").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head><meta charset="utf-8"><title>{{.PlanID}}</title></head>
+<body>
+<p><a href="/">All plans</a></p>
+<img src="/labels/{{.PlanID}}.svg" alt="label of {{.PlanID}}">
+{{.ReloadScript}}
+</body>
+</html>
+`))
+
+// servePlan wraps the label of a plan in a page, which reloads when the csv
+// file changes.
+func (s *previewServer) servePlan(w http.ResponseWriter, r *http.Request) {
+	writePreviewPage(w, planPageTemplate, struct {
+		PlanID       string
+		ReloadScript template.HTML
+	}{strings.TrimPrefix(r.URL.Path, "/plans/"), previewReloadScript})
+}
+
+func writePreviewPage(w http.ResponseWriter, page *template.Template, data interface{}) {
+	var contents bytes.Buffer
+	if err := page.Execute(&contents, data); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write(contents.Bytes())
+}
+
+func (s *previewServer) serveLabel(w http.ResponseWriter, r *http.Request) {
+	planID := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/labels/"), ".svg")
+
+	s.settings.RLock()
+	defer s.settings.RUnlock()
+	if s.settingsErr != nil {
+		http.Error(w, s.settingsErr.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	plan, err := findPlan(csvFileName, planID, 0)
+	if err != nil {
+		_, message := errorMessage(err)
+		http.Error(w, message, http.StatusNotFound)
+		return
+	}
+
+	var label bytes.Buffer
+	if err := renderLabel(&label, plan); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "image/svg+xml")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Write(label.Bytes())
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// outputStateFileName is the file in the output directory listing the files
+// owned by sonarbcd, paths are relative to the output directory.
+const outputStateFileName = ".sonarbcd-state.json"
+
+type outputState struct {
+	Files []string `json:"files"`
+	// the hash of each label, see labelCache
+	Labels map[string]string `json:"labels,omitempty"`
+}
+
+// loadOutputState reads the state of the previous run. An output directory
+// without a state file owns no files.
+func loadOutputState(outputDirectory string) (outputState, error) {
+	var state outputState
+
+	stateFile, err := os.ReadFile(filepath.Join(outputDirectory, outputStateFileName))
+	if errors.Is(err, fs.ErrNotExist) {
+		return state, nil
+	}
+	if err != nil {
+		return state, err
+	}
+
+	if err := json.Unmarshal(stateFile, &state); err != nil {
+		return state, errors.New(outputStateFileName + ": " + err.Error())
+	}
+	return state, nil
+}
+
+func writeOutputState(directory string, files []string, labels map[string]string) error {
+	files = append([]string(nil), files...)
+	sort.Strings(files)
+
+	stateJSON, err := json.MarshalIndent(outputState{Files: files, Labels: labels}, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(directory, outputStateFileName), append(stateJSON, '\n'), 0644)
+}
+
+// stagedFiles lists the files written to the stage by this run.
+func stagedFiles(stage string) ([]string, error) {
+	var files []string
+	err := filepath.WalkDir(stage, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() {
+			return err
+		}
+		relative, err := filepath.Rel(stage, path)
+		if err != nil {
+			return err
+		}
+		files = append(files, filepath.ToSlash(relative))
+		return nil
+	})
+	return files, err
+}
+
+// staleFiles returns the files owned by the previous run that this run didn't
+// write and that are still in the output directory, these belong to plans
+// that have been removed from the csv or renamed.
+func staleFiles(previous outputState, written []string, outputDirectory string) []string {
+	isWritten := make(map[string]bool, len(written))
+	for _, file := range written {
+		isWritten[file] = true
+	}
+
+	var stale []string
+	for _, file := range previous.Files {
+		if isWritten[file] || file == outputStateFileName {
+			continue
+		}
+		if _, err := os.Lstat(filepath.Join(outputDirectory, filepath.FromSlash(file))); err != nil {
+			continue
+		}
+		stale = append(stale, file)
+	}
+	sort.Strings(stale)
+	return stale
+}
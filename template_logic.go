@@ -2,7 +2,9 @@ package main
 
 import (
 	"fmt"
+	"net/url"
 	"regexp"
+	"sort"
 	"strconv"
 	"strings"
 )
@@ -14,9 +16,18 @@ func calculateMonthlyPrice(templateEntry *BroadbandData) error {
 	if err != nil {
 		return err
 	}
+	if billingFrequencyInMonths < 1 {
+		return fmt.Errorf("billing frequency must be at least 1 month, got %d", billingFrequencyInMonths)
+	}
 
 	var priceInCents int
-	if templateEntry.IntroductoryPeriodInMonths == "" && templateEntry.IntroductoryPricePerMonth == "" {
+	if len(templateEntry.PriceSchedule) > 0 {
+		templateEntry.IntroductoryRate = true
+		priceInCents, err = convertPriceToCents(templateEntry.PriceSchedule[0].PricePerMonth)
+		if err != nil {
+			return err
+		}
+	} else if templateEntry.IntroductoryPeriodInMonths == "" && templateEntry.IntroductoryPricePerMonth == "" {
 		priceInCents, err = convertPriceToCents(templateEntry.DataServicePrice)
 		if err != nil {
 			return err
@@ -29,10 +40,158 @@ func calculateMonthlyPrice(templateEntry *BroadbandData) error {
 		}
 	}
 
-	templateEntry.MonthlyPrice = fmt.Sprintf("%.2f", float64(billingFrequencyInMonths*priceInCents)/100)
+	// the label headline is always the monthly equivalent, the amount actually
+	// charged each billing period is carried separately. It is the recurring
+	// charge of the regular price, not of an introductory or promotional price
+	// that only lasts a few billing periods.
+	regularPriceInCents := priceInCents
+	if templateEntry.IntroductoryRate {
+		regularPriceInCents, err = convertPriceToCents(templateEntry.DataServicePrice)
+		if err != nil {
+			return err
+		}
+	}
+	templateEntry.MonthlyPrice = fmt.Sprintf("%.2f", float64(priceInCents)/100)
+	templateEntry.BillingPeriodPrice = fmt.Sprintf("%.2f", float64(billingFrequencyInMonths*regularPriceInCents)/100)
+	templateEntry.BillingPeriodText = billingPeriodText(billingFrequencyInMonths)
 	return nil
 }
 
+func billingPeriodText(billingFrequencyInMonths int) string {
+	switch billingFrequencyInMonths {
+	case 1:
+		return "billed monthly"
+	case 12:
+		return "billed annually"
+	default:
+		return "billed every " + strconv.Itoa(billingFrequencyInMonths) + " months"
+	}
+}
+
+const (
+	promoPeriodField = "promo_period_in_months_"
+	promoPriceField  = "promo_price_per_month_"
+)
+
+// buildPriceSchedule collects the numbered promo_period_in_months_N and
+// promo_price_per_month_N columns into an ordered list of price steps. A row
+// that only uses the single introductory_* columns is returned as a one step
+// schedule so both forms render the same way.
+func buildPriceSchedule(data map[string]string) ([]PriceStep, error) {
+	seenSteps := make(map[int]bool)
+	for fieldName, fieldValue := range data {
+		var prefix string
+		if strings.HasPrefix(fieldName, promoPeriodField) {
+			prefix = promoPeriodField
+		} else if strings.HasPrefix(fieldName, promoPriceField) {
+			prefix = promoPriceField
+		} else {
+			continue
+		}
+
+		stepNumber, err := strconv.Atoi(strings.TrimPrefix(fieldName, prefix))
+		if err != nil || stepNumber < 1 {
+			return nil, fmt.Errorf("invalid promo step number in column %s", fieldName)
+		}
+		if fieldValue != "" {
+			seenSteps[stepNumber] = true
+		}
+	}
+
+	stepNumbers := make([]int, 0, len(seenSteps))
+	for stepNumber := range seenSteps {
+		stepNumbers = append(stepNumbers, stepNumber)
+	}
+
+	if len(stepNumbers) == 0 {
+		if data["introductory_period_in_months"] == "" && data["introductory_price_per_month"] == "" {
+			return nil, nil
+		}
+		period, err := strconv.Atoi(data["introductory_period_in_months"])
+		if err != nil {
+			return nil, err
+		}
+		return []PriceStep{{
+			StepNumber:     1,
+			PeriodInMonths: period,
+			PricePerMonth:  strings.TrimPrefix(data["introductory_price_per_month"], "$"),
+		}}, nil
+	}
+
+	if data["introductory_period_in_months"] != "" || data["introductory_price_per_month"] != "" {
+		return nil, fmt.Errorf("introductory_* and promo_* columns can not be used together")
+	}
+
+	sort.Ints(stepNumbers)
+	schedule := make([]PriceStep, 0, len(stepNumbers))
+	for i, stepNumber := range stepNumbers {
+		if stepNumber != i+1 {
+			return nil, fmt.Errorf("promo steps must be numbered from 1 without gaps, missing step %d", i+1)
+		}
+
+		stepIndex := strconv.Itoa(stepNumber)
+		period, err := strconv.Atoi(data[promoPeriodField+stepIndex])
+		if err != nil || period < 1 {
+			return nil, fmt.Errorf("promo step %d period must be a whole number of months, csv value: %s", stepNumber, data[promoPeriodField+stepIndex])
+		}
+
+		price := data[promoPriceField+stepIndex]
+		if price == "" {
+			return nil, fmt.Errorf("promo step %d has a period but no price", stepNumber)
+		}
+
+		schedule = append(schedule, PriceStep{
+			StepNumber:     stepNumber,
+			PeriodInMonths: period,
+			PricePerMonth:  strings.TrimPrefix(price, "$"),
+		})
+	}
+	return schedule, nil
+}
+
+// parseContractDuration reads contract_duration, an empty or 0 value is a plan
+// without a contract.
+func parseContractDuration(value string) (months int, hasContract bool, err error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0, false, nil
+	}
+	months, err = strconv.Atoi(value)
+	if err != nil || months < 0 {
+		return 0, false, fmt.Errorf("contract_duration must be a whole number of months, csv value: %s", value)
+	}
+	return months, months > 0, nil
+}
+
+// priceScheduleLength returns the total number of months covered by the
+// schedule.
+func priceScheduleLength(schedule []PriceStep) int {
+	total := 0
+	for _, step := range schedule {
+		total += step.PeriodInMonths
+	}
+	return total
+}
+
+// uniquePlanID builds the FCC unique plan identifier from the service type,
+// the provider's FCC id and the zero padded data service id.
+func uniquePlanID(templateEntry BroadbandData) string {
+	fixedMobile := "M"
+	if templateEntry.FixedOrMobile == "Fixed" {
+		fixedMobile = "F"
+	}
+	serviceID := templateEntry.DataServiceID
+	if len(serviceID) < 15 {
+		serviceID = strings.Repeat("0", 15-len(serviceID)) + serviceID
+	}
+	return fixedMobile + templateEntry.FccID + serviceID
+}
+
+// planURL is the hosted location of a plan's label under baseURL.
+func planURL(baseURL, planID string) string {
+	return strings.TrimSuffix(baseURL, "/") + "/" + url.PathEscape(planID)
+}
+
 func isSpeedAnInteger(speed float64) bool {
 	str := strconv.FormatFloat(speed, 'f', -1, 64)
 	isInteger := str == strconv.Itoa(int(speed))
@@ -2,16 +2,20 @@ package main
 
 import (
 	"errors"
+	"path/filepath"
+	"reflect"
 	"strconv"
 	"testing"
 )
 
 func TestCalculateMonthlyPrice(t *testing.T) {
 	testCases := []struct {
-		name           string
-		templateData   BroadbandData
-		expectedResult string
-		expectedError  error
+		name                string
+		templateData        BroadbandData
+		expectedResult      string
+		expectedPeriodPrice string
+		expectedPeriodText  string
+		expectedError       error
 	}{
 		{
 			name: "No Introductory Period",
@@ -19,17 +23,22 @@ func TestCalculateMonthlyPrice(t *testing.T) {
 				BillingFrequencyInMonths: "12",
 				DataServicePrice:         "$100.00",
 			},
-			expectedResult: "1200.00",
-			expectedError:  nil,
+			expectedResult:      "100.00",
+			expectedPeriodPrice: "1200.00",
+			expectedPeriodText:  "billed annually",
+			expectedError:       nil,
 		},
 		{
 			name: "With Introductory Period",
 			templateData: BroadbandData{
 				BillingFrequencyInMonths:  "12",
+				DataServicePrice:          "$100.00",
 				IntroductoryPricePerMonth: "$80.00",
 			},
-			expectedResult: "960.00",
-			expectedError:  nil,
+			expectedResult:      "80.00",
+			expectedPeriodPrice: "1200.00",
+			expectedPeriodText:  "billed annually",
+			expectedError:       nil,
 		},
 		{
 			name: "Invalid Billing Frequency",
@@ -40,6 +49,15 @@ func TestCalculateMonthlyPrice(t *testing.T) {
 			expectedResult: "",
 			expectedError:  errors.New(`strconv.Atoi: parsing "invalid": invalid syntax`),
 		},
+		{
+			name: "Zero Billing Frequency",
+			templateData: BroadbandData{
+				BillingFrequencyInMonths: "0",
+				DataServicePrice:         "$100.00",
+			},
+			expectedResult: "",
+			expectedError:  errors.New(`billing frequency must be at least 1 month, got 0`),
+		},
 		{
 			name: "Invalid Price Format",
 			templateData: BroadbandData{
@@ -55,17 +73,81 @@ func TestCalculateMonthlyPrice(t *testing.T) {
 				BillingFrequencyInMonths: "12",
 				DataServicePrice:         "100.00",
 			},
-			expectedResult: "1200.00",
-			expectedError:  nil,
+			expectedResult:      "100.00",
+			expectedPeriodPrice: "1200.00",
+			expectedPeriodText:  "billed annually",
+			expectedError:       nil,
 		},
 		{
 			name: "With Introductory Period, no $",
 			templateData: BroadbandData{
 				BillingFrequencyInMonths:  "12",
+				DataServicePrice:          "100.00",
 				IntroductoryPricePerMonth: "80.00",
 			},
-			expectedResult: "960.00",
-			expectedError:  nil,
+			expectedResult:      "80.00",
+			expectedPeriodPrice: "1200.00",
+			expectedPeriodText:  "billed annually",
+			expectedError:       nil,
+		},
+		{
+			name: "Monthly Billing",
+			templateData: BroadbandData{
+				BillingFrequencyInMonths: "1",
+				DataServicePrice:         "$74.95",
+			},
+			expectedResult:      "74.95",
+			expectedPeriodPrice: "74.95",
+			expectedPeriodText:  "billed monthly",
+			expectedError:       nil,
+		},
+		{
+			name: "Quarterly Billing",
+			templateData: BroadbandData{
+				BillingFrequencyInMonths: "3",
+				DataServicePrice:         "$49.99",
+			},
+			expectedResult:      "49.99",
+			expectedPeriodPrice: "149.97",
+			expectedPeriodText:  "billed every 3 months",
+			expectedError:       nil,
+		},
+		{
+			name: "Quarterly Billing With Introductory Period",
+			templateData: BroadbandData{
+				BillingFrequencyInMonths:   "3",
+				DataServicePrice:           "$70.00",
+				IntroductoryPeriodInMonths: "6",
+				IntroductoryPricePerMonth:  "$40.00",
+			},
+			// the amount billed is the regular recurring charge
+			expectedResult:      "40.00",
+			expectedPeriodPrice: "210.00",
+			expectedPeriodText:  "billed every 3 months",
+			expectedError:       nil,
+		},
+		{
+			name: "Quarterly Billing With Promo Steps",
+			templateData: BroadbandData{
+				BillingFrequencyInMonths: "3",
+				DataServicePrice:         "$70.00",
+				PriceSchedule:            []PriceStep{{StepNumber: 1, PeriodInMonths: 3, PricePerMonth: "30.00"}, {StepNumber: 2, PeriodInMonths: 3, PricePerMonth: "50.00"}},
+			},
+			expectedResult:      "30.00",
+			expectedPeriodPrice: "210.00",
+			expectedPeriodText:  "billed every 3 months",
+			expectedError:       nil,
+		},
+		{
+			name: "Annual Billing",
+			templateData: BroadbandData{
+				BillingFrequencyInMonths: "12",
+				DataServicePrice:         "$59.95",
+			},
+			expectedResult:      "59.95",
+			expectedPeriodPrice: "719.40",
+			expectedPeriodText:  "billed annually",
+			expectedError:       nil,
 		},
 	}
 
@@ -84,6 +166,37 @@ func TestCalculateMonthlyPrice(t *testing.T) {
 			if testCase.expectedResult != testCase.templateData.MonthlyPrice {
 				t.Errorf("Expected result: %s, got: %s", testCase.expectedResult, testCase.templateData.MonthlyPrice)
 			}
+
+			if testCase.expectedPeriodPrice != testCase.templateData.BillingPeriodPrice {
+				t.Errorf("Expected billing period price: %s, got: %s", testCase.expectedPeriodPrice, testCase.templateData.BillingPeriodPrice)
+			}
+
+			if testCase.expectedPeriodText != testCase.templateData.BillingPeriodText {
+				t.Errorf("Expected billing period text: %s, got: %s", testCase.expectedPeriodText, testCase.templateData.BillingPeriodText)
+			}
+		})
+	}
+}
+
+func TestBillingPeriodText(t *testing.T) {
+	tests := []struct {
+		months   int
+		expected string
+	}{
+		{1, "billed monthly"},
+		{2, "billed every 2 months"},
+		{3, "billed every 3 months"},
+		{6, "billed every 6 months"},
+		{12, "billed annually"},
+		{24, "billed every 24 months"},
+	}
+
+	for _, test := range tests {
+		t.Run(strconv.Itoa(test.months), func(t *testing.T) {
+			result := billingPeriodText(test.months)
+			if result != test.expected {
+				t.Errorf("billingPeriodText(%d) = %q; want %q", test.months, result, test.expected)
+			}
 		})
 	}
 }
@@ -227,3 +340,215 @@ func TestCalculateUploadDownloadSpeeds(t *testing.T) {
 		})
 	}
 }
+
+func TestBuildPriceSchedule(t *testing.T) {
+	tests := []struct {
+		description   string
+		data          map[string]string
+		expectedSteps []PriceStep
+		expectedError bool
+	}{
+		{
+			description:   "No introductory or promo columns",
+			data:          map[string]string{"data_service_price": "70.00"},
+			expectedSteps: nil,
+			expectedError: false,
+		},
+		{
+			description: "Single introductory period",
+			data: map[string]string{
+				"introductory_period_in_months": "6",
+				"introductory_price_per_month":  "$50.00",
+			},
+			expectedSteps: []PriceStep{{StepNumber: 1, PeriodInMonths: 6, PricePerMonth: "50.00"}},
+			expectedError: false,
+		},
+		{
+			description: "Multi step promo",
+			data: map[string]string{
+				"promo_period_in_months_2": "6",
+				"promo_price_per_month_2":  "$55.00",
+				"promo_period_in_months_1": "6",
+				"promo_price_per_month_1":  "$40.00",
+				"promo_period_in_months_3": "",
+				"promo_price_per_month_3":  "",
+			},
+			expectedSteps: []PriceStep{
+				{StepNumber: 1, PeriodInMonths: 6, PricePerMonth: "40.00"},
+				{StepNumber: 2, PeriodInMonths: 6, PricePerMonth: "55.00"},
+			},
+			expectedError: false,
+		},
+		{
+			description: "Gap in promo steps",
+			data: map[string]string{
+				"promo_period_in_months_1": "6",
+				"promo_price_per_month_1":  "40.00",
+				"promo_period_in_months_3": "6",
+				"promo_price_per_month_3":  "55.00",
+			},
+			expectedError: true,
+		},
+		{
+			description: "Promo step missing price",
+			data: map[string]string{
+				"promo_period_in_months_1": "6",
+			},
+			expectedError: true,
+		},
+		{
+			description: "Promo step missing period",
+			data: map[string]string{
+				"promo_price_per_month_1": "40.00",
+			},
+			expectedError: true,
+		},
+		{
+			description: "Introductory and promo columns mixed",
+			data: map[string]string{
+				"introductory_period_in_months": "6",
+				"introductory_price_per_month":  "50.00",
+				"promo_period_in_months_1":      "6",
+				"promo_price_per_month_1":       "40.00",
+			},
+			expectedError: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			schedule, err := buildPriceSchedule(test.data)
+
+			if test.expectedError && err == nil {
+				t.Errorf("Expected an error but got none")
+			}
+
+			if !test.expectedError && err != nil {
+				t.Errorf("Unexpected error: %v", err)
+			}
+
+			if !reflect.DeepEqual(schedule, test.expectedSteps) && !test.expectedError {
+				t.Errorf("Schedule mismatch. Got %v, expected %v", schedule, test.expectedSteps)
+			}
+		})
+	}
+}
+
+func TestLabelFileNames(t *testing.T) {
+	plans := []BroadbandData{
+		{CsvRow: 2, CompanyName: "Live Oak / Fiber", DataServiceName: "500x500", DataServiceID: "10", FccID: "65489", FixedOrMobile: "Fixed"},
+		{CsvRow: 3, CompanyName: "Live Oak / Fiber", DataServiceName: "Home (Basic)", DataServiceID: "51", FccID: "65489", FixedOrMobile: "Fixed"},
+	}
+
+	tests := []struct {
+		pattern       string
+		expectedNames []string
+		expectError   bool
+	}{
+		{pattern: "{{.PlanID}}", expectedNames: []string{"F65489000000000000010.svg", "F65489000000000000051.svg"}},
+		{pattern: "{{.Company}}_{{.ServiceName}}.svg", expectedNames: []string{"Live-Oak-Fiber_500x500.svg", "Live-Oak-Fiber_Home-Basic.svg"}},
+		{pattern: "label_{{.Index}}", expectedNames: []string{"label_0.svg", "label_1.svg"}},
+		{pattern: "{{.Company}}", expectError: true},
+		{pattern: "{{.Company}}/{{.PlanID}}", expectError: true},
+		{pattern: "{{.Unknown}}", expectError: true},
+	}
+
+	for _, test := range tests {
+		var names []string
+		fileNamer, err := newLabelFileNamer(test.pattern)
+		for _, plan := range plans {
+			if err != nil {
+				break
+			}
+			var name string
+			name, err = fileNamer.next(plan)
+			names = append(names, name)
+		}
+
+		if test.expectError {
+			if err == nil {
+				t.Errorf("file names for %q expected an error, got %v", test.pattern, names)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("file names for %q returned an error: %v", test.pattern, err)
+			continue
+		}
+		if !reflect.DeepEqual(names, test.expectedNames) {
+			t.Errorf("file names for %q = %v, expected %v", test.pattern, names, test.expectedNames)
+		}
+	}
+}
+
+func TestLabelGroups(t *testing.T) {
+	plans := []BroadbandData{
+		{CsvRow: 2, CompanyName: "Greystar", DataServiceID: "51", FccID: "12345"},
+		{CsvRow: 3, CompanyName: "Live Oak Fiber", DataServiceID: "51", FccID: "65489"},
+		{CsvRow: 4, CompanyName: "Greystar", DataServiceID: "10", FccID: "12345"},
+	}
+
+	groups, err := newLabelGroups(groupByCompany, t.TempDir(), defaultFileNamePattern)
+	if err != nil {
+		t.Fatalf("newLabelGroups returned an error: %v", err)
+	}
+	for _, plan := range plans {
+		if _, _, err := groups.add(plan); err != nil {
+			t.Fatalf("add returned an error: %v", err)
+		}
+	}
+
+	if len(groups.groups) != 2 {
+		t.Fatalf("found %d groups, expected 2", len(groups.groups))
+	}
+	if first := groups.groups[0]; filepath.Base(first.directory) != "Greystar" || len(first.labels) != 2 {
+		t.Errorf("first group is %s with %d labels, expected Greystar with 2", first.directory, len(first.labels))
+	}
+	if second := groups.groups[1]; filepath.Base(second.directory) != "Live-Oak-Fiber" || len(second.labels) != 1 {
+		t.Errorf("second group is %s with %d labels, expected Live-Oak-Fiber with 1", second.directory, len(second.labels))
+	}
+
+	if _, _, err := groups.add(BroadbandData{CsvRow: 5, CompanyName: "Live-Oak Fiber", DataServiceID: "1", FccID: "1"}); err == nil {
+		t.Errorf("add expected an error for two companies sharing a directory")
+	}
+}
+
+func TestValidatePriceScheduleContract(t *testing.T) {
+	introductory := map[string]string{"introductory_period_in_months": "6", "introductory_price_per_month": "40.00"}
+	promo := map[string]string{"promo_period_in_months_1": "6", "promo_price_per_month_1": "40.00", "promo_period_in_months_2": "6", "promo_price_per_month_2": "55.00"}
+
+	tests := []struct {
+		description      string
+		schedule         map[string]string
+		contractDuration string
+		expectedError    bool
+	}{
+		{"Introductory without a contract", introductory, "", false},
+		{"Introductory on a 0 month contract", introductory, "0", false},
+		{"Introductory within the contract", introductory, "12", false},
+		{"Introductory longer than the contract", introductory, "3", true},
+		{"Introductory with an invalid contract", introductory, "x", true},
+		{"Promo without a contract", promo, "", false},
+		{"Promo on a 0 month contract", promo, "0", false},
+		{"Promo within the contract", promo, "12", false},
+		{"Promo longer than the contract", promo, "6", true},
+		{"Promo with a negative contract", promo, "-1", true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			data := map[string]string{"csvrow": "2", "contract_duration": test.contractDuration}
+			for column, value := range test.schedule {
+				data[column] = value
+			}
+
+			err := validatePriceSchedule(data)
+			if test.expectedError && err == nil {
+				t.Errorf("Expected an error but got none")
+			}
+			if !test.expectedError && err != nil {
+				t.Errorf("Unexpected error: %v", err)
+			}
+		})
+	}
+}
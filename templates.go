@@ -0,0 +1,232 @@
+package main
+
+import (
+	"crypto/sha256"
+	"embed"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+)
+
+//go:embed templates/*.tmpl
+var builtinTemplates embed.FS
+
+// LabelTemplateData is the data model the label templates are executed with.
+// It has every field of BroadbandData plus the values worked out from them.
+type LabelTemplateData struct {
+	BroadbandData
+	PlanID    string
+	PlanURL   string
+	LinkColor string
+	RuleColor string
+
+	// only set for the label document template
+	Width   int
+	Height  int
+	Content string
+}
+
+// PromoStepTemplateData is used for the promo_step and price_after_promo
+// templates.
+type PromoStepTemplateData struct {
+	LabelTemplateData
+	StartMonth    int
+	EndMonth      int
+	PricePerMonth string
+}
+
+// IntroductoryTemplateData is used for the introductory_period_value template.
+type IntroductoryTemplateData struct {
+	LabelTemplateData
+	IntroductoryMonths string
+}
+
+var labelTemplates *template.Template
+
+// labelTemplatesVersion is a hash of the source of the templates in use, it
+// changes whenever a built-in or overriding template changes.
+var labelTemplatesVersion string
+
+// each text style is read from the style_* template of the same name
+var labelStyleTemplates = map[*string]string{
+	&labelTitle:                                 "style_title",
+	&labelTitleAnchorEnd:                        "style_title_anchor_end",
+	&labelCompanyName:                           "style_company_name",
+	&labelPackageName:                           "style_package_name",
+	&labelGenericTextNormal:                     "style_text",
+	&labelGenericTextNormalBold:                 "style_text_bold",
+	&labelGenericTextNormalBoldAnchorEnd:        "style_value_bold_anchor_end",
+	&labelGenericTextNormalHeavyBoldAnchorEnd:   "style_value_heavy_anchor_end",
+	&labelGenericTextNormalHeavyBoldAnchorStart: "style_value_heavy_anchor_start",
+	&labelMonthlyPrice:                          "style_monthly_price",
+	&labelMonthlyPriceValue:                     "style_monthly_price_value",
+	&labelSectionHeading:                        "style_section_heading",
+	&labelFccLink:                               "style_fcc_link",
+	&labelUniquePlanId:                          "style_unique_plan_id",
+}
+
+var labelTemplateFuncs = template.FuncMap{
+	"sub": func(a, b int) int {
+		return a - b
+	},
+	// article is "an" for numbers that are read starting with a vowel sound
+	"article": func(months string) string {
+		switch months {
+		case "8", "11", "18":
+			return "an"
+		}
+		return "a"
+	},
+}
+
+func init() {
+	if err := loadLabelTemplates(""); err != nil {
+		panic(err)
+	}
+}
+
+// loadLabelTemplates parses the built-in templates and then any *.tmpl files
+// in templateDirectory. A template defined in the directory replaces the
+// built-in template of the same name.
+func loadLabelTemplates(templateDirectory string) error {
+	t, err := template.New("sonarbcd").Funcs(labelTemplateFuncs).ParseFS(builtinTemplates, "templates/*.tmpl")
+	if err != nil {
+		return err
+	}
+
+	version := sha256.New()
+	builtins, err := fs.Glob(builtinTemplates, "templates/*.tmpl")
+	if err != nil {
+		return err
+	}
+	for _, name := range builtins {
+		source, err := builtinTemplates.ReadFile(name)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(version, "%s %d\n%s", name, len(source), source)
+	}
+
+	if templateDirectory != "" {
+		overrides, err := filepath.Glob(filepath.Join(templateDirectory, "*.tmpl"))
+		if err != nil {
+			return err
+		}
+		if len(overrides) == 0 {
+			return fmt.Errorf("templates: no .tmpl files found in %s", templateDirectory)
+		}
+		t, err = t.ParseFiles(overrides...)
+		if err != nil {
+			return fmt.Errorf("templates: %v", err)
+		}
+		for _, name := range overrides {
+			source, err := os.ReadFile(name)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(version, "%s %d\n%s", filepath.Base(name), len(source), source)
+		}
+	}
+
+	for style, name := range labelStyleTemplates {
+		value, err := executeLabelTemplate(t, name, LabelTemplateData{})
+		if err != nil {
+			return err
+		}
+		*style = value
+	}
+
+	labelTemplates = t
+	labelTemplatesVersion = hex.EncodeToString(version.Sum(nil))
+	return nil
+}
+
+func executeLabelTemplate(t *template.Template, name string, data interface{}) (string, error) {
+	var result strings.Builder
+	if err := t.ExecuteTemplate(&result, name, data); err != nil {
+		return "", fmt.Errorf("templates: %v", err)
+	}
+	return strings.TrimSpace(result.String()), nil
+}
+
+// labelText returns the wording of one of the text templates for a plan.
+func labelText(name string, data interface{}) (string, error) {
+	return executeLabelTemplate(labelTemplates, name, data)
+}
+
+// labelTexts executes the text templates of a label section and keeps the
+// first error, so a section can be written as a list of rows and return the
+// error once at the end.
+type labelTexts struct {
+	err error
+}
+
+func (t *labelTexts) text(name string, data interface{}) string {
+	if t.err != nil {
+		return ""
+	}
+	var text string
+	text, t.err = labelText(name, data)
+	return text
+}
+
+func newLabelTemplateData(template BroadbandData) LabelTemplateData {
+	data := LabelTemplateData{
+		BroadbandData: template,
+		PlanID:        uniquePlanID(template),
+		LinkColor:     labelTheme.LinkColor,
+		RuleColor:     labelTheme.RuleColor,
+	}
+	if qrBaseURL != "" {
+		data.PlanURL = planURL(qrBaseURL, data.PlanID)
+	}
+	return data
+}
+
+// exportTemplates writes the built-in templates to directory as a starting
+// point for customised templates.
+func exportTemplates(directory string, overwrite bool) error {
+	if err := os.MkdirAll(directory, 0755); err != nil {
+		return err
+	}
+
+	return fs.WalkDir(builtinTemplates, "templates", func(path string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() {
+			return err
+		}
+
+		target := filepath.Join(directory, entry.Name())
+		if _, err := os.Stat(target); err == nil && !overwrite {
+			return fmt.Errorf("templates: %s already exists, use -force to overwrite it", target)
+		}
+
+		contents, err := builtinTemplates.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		return os.WriteFile(target, contents, 0644)
+	})
+}
+
+func templatesCommand(args []string) error {
+	if len(args) == 0 || args[0] != "export" {
+		return fmt.Errorf("usage: sonarbcd templates export [-dir directory] [-force]")
+	}
+
+	flags := newCommandFlags("templates export", "[options]", "Writes the built-in label templates to a directory as a starting point for customised templates, see -templatedir.")
+	directory := flags.String("dir", "templates", "the directory to write the built-in templates to")
+	overwrite := flags.Bool("force", false, "overwrite existing template files")
+	if err := parseCommandFlags(flags, args[1:]); err != nil {
+		return err
+	}
+
+	if err := exportTemplates(*directory, *overwrite); err != nil {
+		return err
+	}
+	logInfo("wrote the built-in templates to %q", *directory)
+	return nil
+}
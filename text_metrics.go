@@ -0,0 +1,63 @@
+package main
+
+import (
+	"math"
+	"regexp"
+	"strconv"
+)
+
+// advance widths of Roboto Regular in font units, 2048 units to the em
+var robotoAdvanceWidths = map[rune]int{
+	' ': 507, '!': 527, '"': 655, '#': 1257, '$': 1123, '%': 1465, '&': 1274, '\'': 357,
+	'(': 684, ')': 696, '*': 861, '+': 1135, ',': 393, '-': 553, '.': 539, '/': 824,
+	'0': 1150, '1': 1150, '2': 1150, '3': 1150, '4': 1150, '5': 1150, '6': 1150, '7': 1150,
+	'8': 1150, '9': 1150, ':': 484, ';': 434, '<': 1040, '=': 1124, '>': 1070, '?': 946,
+	'@': 1838, 'A': 1336, 'B': 1275, 'C': 1333, 'D': 1343, 'E': 1164, 'F': 1132, 'G': 1395,
+	'H': 1460, 'I': 557, 'J': 1130, 'K': 1284, 'L': 1102, 'M': 1788, 'N': 1460, 'O': 1408,
+	'P': 1292, 'Q': 1408, 'R': 1261, 'S': 1217, 'T': 1222, 'U': 1328, 'V': 1303, 'W': 1817,
+	'X': 1284, 'Y': 1229, 'Z': 1226, '[': 543, '\\': 840, ']': 543, '_': 905, 'a': 1089,
+	'b': 1122, 'c': 1046, 'd': 1126, 'e': 1058, 'f': 709, 'g': 1120, 'h': 1103, 'i': 486,
+	'j': 478, 'k': 1015, 'l': 486, 'm': 1753, 'n': 1105, 'o': 1142, 'p': 1120, 'q': 1126,
+	'r': 678, 's': 1031, 't': 655, 'u': 1103, 'v': 969, 'w': 1503, 'x': 993, 'y': 947,
+	'z': 993, '{': 691, '|': 498, '}': 691, '~': 1393,
+}
+
+const robotoAverageAdvance = 1100
+
+var (
+	fontSizePattern   = regexp.MustCompile(`font-size:(\d+(?:\.\d+)?)pt`)
+	fontWeightPattern = regexp.MustCompile(`font-weight:(\d+|bold)`)
+)
+
+// measureText estimates the rendered width of text in user units from the
+// font-size and font-weight of an svg style. Heavier weights are scaled up
+// from the regular advance widths.
+func measureText(text, style string) int {
+	fontSize := 12.0
+	if match := fontSizePattern.FindStringSubmatch(style); match != nil {
+		fontSize, _ = strconv.ParseFloat(match[1], 64)
+	}
+
+	weightScale := 1.0
+	if match := fontWeightPattern.FindStringSubmatch(style); match != nil {
+		weight := 700
+		if match[1] != "bold" {
+			weight, _ = strconv.Atoi(match[1])
+		}
+		if weight > 400 {
+			weightScale += float64(weight-400) / 500 * 0.1
+		}
+	}
+
+	units := 0
+	for _, r := range text {
+		advance, ok := robotoAdvanceWidths[r]
+		if !ok {
+			advance = robotoAverageAdvance
+		}
+		units += advance
+	}
+
+	// svg user units are px, 1pt is 4/3 px
+	return int(math.Ceil(float64(units) / 2048 * fontSize * 4 / 3 * weightScale))
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// LabelTheme holds the branding that may be applied to a label. Only the logo
+// and colors can be changed, the sections, wording and order required by the
+// FCC are always rendered as is.
+type LabelTheme struct {
+	LogoFile      string `json:"logo"`
+	LogoMaxWidth  int    `json:"logo_max_width"`
+	LogoMaxHeight int    `json:"logo_max_height"`
+	LinkColor     string `json:"link_color"`
+	RuleColor     string `json:"rule_color"`
+
+	logoDataURI string
+}
+
+const (
+	themeMaxLogoBytes  = 256 * 1024
+	themeMaxLogoWidth  = 160
+	themeMaxLogoHeight = 56
+
+	// WCAG 2.1 minimums against the white label background, 1.4.3 for link
+	// text and 1.4.11 for the rules which are non-text graphics.
+	themeMinLinkContrast = 4.5
+	themeMinRuleContrast = 3.0
+)
+
+var labelTheme = defaultLabelTheme()
+
+func defaultLabelTheme() LabelTheme {
+	return LabelTheme{
+		LogoMaxWidth:  120,
+		LogoMaxHeight: 40,
+		LinkColor:     "#0000EE",
+		RuleColor:     "#000000",
+	}
+}
+
+func loadLabelTheme(themeFileName string) (LabelTheme, error) {
+	theme := defaultLabelTheme()
+
+	themeFile, err := os.ReadFile(themeFileName)
+	if err != nil {
+		return theme, err
+	}
+
+	if err := json.Unmarshal(themeFile, &theme); err != nil {
+		return theme, fmt.Errorf("theme: %s: %v", themeFileName, err)
+	}
+
+	if err := validateThemeColor("link_color", theme.LinkColor, themeMinLinkContrast); err != nil {
+		return theme, err
+	}
+	if err := validateThemeColor("rule_color", theme.RuleColor, themeMinRuleContrast); err != nil {
+		return theme, err
+	}
+
+	if theme.LogoMaxWidth < 1 || theme.LogoMaxWidth > themeMaxLogoWidth {
+		return theme, fmt.Errorf("theme: logo_max_width must be between 1 and %d", themeMaxLogoWidth)
+	}
+	if theme.LogoMaxHeight < 1 || theme.LogoMaxHeight > themeMaxLogoHeight {
+		return theme, fmt.Errorf("theme: logo_max_height must be between 1 and %d", themeMaxLogoHeight)
+	}
+
+	if theme.LogoFile != "" {
+		// a relative logo path is relative to the theme file
+		logoFileName := theme.LogoFile
+		if !filepath.IsAbs(logoFileName) {
+			logoFileName = filepath.Join(filepath.Dir(themeFileName), logoFileName)
+		}
+		theme.logoDataURI, err = logoToDataURI(logoFileName)
+		if err != nil {
+			return theme, err
+		}
+	}
+
+	return theme, nil
+}
+
+func logoToDataURI(logoFileName string) (string, error) {
+	logo, err := os.ReadFile(logoFileName)
+	if err != nil {
+		return "", err
+	}
+
+	if len(logo) > themeMaxLogoBytes {
+		return "", fmt.Errorf("theme: logo %s must be less than %d KB", logoFileName, themeMaxLogoBytes/1024)
+	}
+
+	mimeType := http.DetectContentType(logo)
+	if strings.ToLower(filepath.Ext(logoFileName)) == ".svg" {
+		mimeType = "image/svg+xml"
+	}
+
+	switch mimeType {
+	case "image/png", "image/jpeg", "image/svg+xml":
+	default:
+		return "", fmt.Errorf("theme: logo %s must be a png, jpeg or svg image, found %s", logoFileName, mimeType)
+	}
+
+	return "data:" + mimeType + ";base64," + base64.StdEncoding.EncodeToString(logo), nil
+}
+
+func validateThemeColor(name, color string, minContrast float64) error {
+	luminance, err := relativeLuminance(color)
+	if err != nil {
+		return fmt.Errorf("theme: %s %v", name, err)
+	}
+
+	// contrast against the white label background, which has a luminance of 1
+	contrast := 1.05 / (luminance + 0.05)
+	if contrast < minContrast {
+		return fmt.Errorf("theme: %s %s has a contrast ratio of %.2f:1 against white, at least %.1f:1 is required", name, color, contrast, minContrast)
+	}
+	return nil
+}
+
+var hexColorPattern = regexp.MustCompile(`^#([0-9a-fA-F]{3}|[0-9a-fA-F]{6})$`)
+
+// relativeLuminance implements the WCAG 2.1 relative luminance of a #rgb or
+// #rrggbb color.
+func relativeLuminance(color string) (float64, error) {
+	if !hexColorPattern.MatchString(color) {
+		return 0, fmt.Errorf("must be a hex color like #1a2b3c, got %q", color)
+	}
+
+	hex := color[1:]
+	if len(hex) == 3 {
+		hex = string([]byte{hex[0], hex[0], hex[1], hex[1], hex[2], hex[2]})
+	}
+
+	var channels [3]float64
+	for i := range channels {
+		value, _ := strconv.ParseUint(hex[i*2:i*2+2], 16, 8)
+		c := float64(value) / 255
+		if c <= 0.04045 {
+			channels[i] = c / 12.92
+		} else {
+			channels[i] = math.Pow((c+0.055)/1.055, 2.4)
+		}
+	}
+
+	return 0.2126*channels[0] + 0.7152*channels[1] + 0.0722*channels[2], nil
+}
+
+func linkStyle() string {
+	return "fill:" + labelTheme.LinkColor
+}
+
+func ruleStyle(strokeWidth int) string {
+	return "stroke:" + labelTheme.RuleColor + ";stroke-width:" + strconv.Itoa(strokeWidth)
+}
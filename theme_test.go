@@ -0,0 +1,178 @@
+package main
+
+import (
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRelativeLuminance(t *testing.T) {
+	for _, test := range []struct {
+		color     string
+		luminance float64
+		valid     bool
+	}{
+		{"#000000", 0, true},
+		{"#FFFFFF", 1, true},
+		{"#fff", 1, true},
+		{"#FF0000", 0.2126, true},
+		{"#00ff00", 0.7152, true},
+		{"#00F", 0.0722, true},
+		{"#767676", 0.1812, true},
+		{"red", 0, false},
+		{"#12345", 0, false},
+		{"#GGGGGG", 0, false},
+		{"000000", 0, false},
+	} {
+		luminance, err := relativeLuminance(test.color)
+		if (err == nil) != test.valid {
+			t.Errorf("relativeLuminance(%q) returned the error %v, expected valid to be %t", test.color, err, test.valid)
+			continue
+		}
+		if math.Abs(luminance-test.luminance) > 0.0001 {
+			t.Errorf("relativeLuminance(%q) = %.4f, expected %.4f", test.color, luminance, test.luminance)
+		}
+	}
+}
+
+func TestValidateThemeColor(t *testing.T) {
+	for _, test := range []struct {
+		color       string
+		minContrast float64
+		valid       bool
+	}{
+		// #767676 is the lightest gray with a 4.5:1 contrast against white
+		{"#767676", themeMinLinkContrast, true},
+		{"#777777", themeMinLinkContrast, false},
+		{"#777777", themeMinRuleContrast, true},
+		{"#0000EE", themeMinLinkContrast, true},
+		{"#000", themeMinRuleContrast, true},
+		{"#FFFF00", themeMinRuleContrast, false},
+		{"#FFFFFF", themeMinRuleContrast, false},
+		{"blue", themeMinLinkContrast, false},
+	} {
+		err := validateThemeColor("link_color", test.color, test.minContrast)
+		if (err == nil) != test.valid {
+			t.Errorf("validateThemeColor(%q, %.1f) returned %v, expected valid to be %t", test.color, test.minContrast, err, test.valid)
+		}
+	}
+}
+
+func TestLogoToDataURI(t *testing.T) {
+	directory := t.TempDir()
+	png := "\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR"
+	writeTestFile(t, filepath.Join(directory, "logo.png"), png)
+	writeTestFile(t, filepath.Join(directory, "logo.jpg"), "\xff\xd8\xff\xe0\x00\x10JFIF")
+	writeTestFile(t, filepath.Join(directory, "logo.svg"), `<svg xmlns="http://www.w3.org/2000/svg"></svg>`)
+	writeTestFile(t, filepath.Join(directory, "logo.gif"), "GIF89a")
+	writeTestFile(t, filepath.Join(directory, "large.png"), png+strings.Repeat("\x00", themeMaxLogoBytes))
+
+	for _, test := range []struct {
+		fileName, prefix, err string
+	}{
+		{"logo.png", "data:image/png;base64,", ""},
+		{"logo.jpg", "data:image/jpeg;base64,", ""},
+		{"logo.svg", "data:image/svg+xml;base64,", ""},
+		{"logo.gif", "", "must be a png, jpeg or svg image"},
+		{"large.png", "", "must be less than 256 KB"},
+		{"missing.png", "", "no such file"},
+		{".", "", ""},
+	} {
+		uri, err := logoToDataURI(filepath.Join(directory, test.fileName))
+		if test.prefix != "" {
+			if err != nil || !strings.HasPrefix(uri, test.prefix) {
+				t.Errorf("logoToDataURI(%s) = %.40q, %v, expected a %s uri", test.fileName, uri, err, test.prefix)
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("logoToDataURI(%s) returned %v, expected an error containing %q", test.fileName, err, test.err)
+		}
+	}
+}
+
+func TestLoadLabelTheme(t *testing.T) {
+	directory := t.TempDir()
+	writeTestFile(t, filepath.Join(directory, "logo.svg"), `<svg xmlns="http://www.w3.org/2000/svg"></svg>`)
+
+	load := func(contents string) (LabelTheme, error) {
+		t.Helper()
+		themeFileName := filepath.Join(directory, "theme.json")
+		writeTestFile(t, themeFileName, contents)
+		return loadLabelTheme(themeFileName)
+	}
+
+	theme, err := load(`{}`)
+	if err != nil || theme != defaultLabelTheme() {
+		t.Errorf("an empty theme gave %+v, %v, expected the defaults %+v", theme, err, defaultLabelTheme())
+	}
+
+	// a relative logo is found next to the theme file
+	theme, err = load(`{"logo": "logo.svg", "logo_max_width": 160, "link_color": "#1A0DAB", "rule_color": "#333"}`)
+	if err != nil {
+		t.Fatalf("loadLabelTheme returned an error: %v", err)
+	}
+	if theme.LogoMaxWidth != 160 || theme.LogoMaxHeight != defaultLabelTheme().LogoMaxHeight || theme.LinkColor != "#1A0DAB" || theme.RuleColor != "#333" {
+		t.Errorf("expected the overrides and the default logo height, got %+v", theme)
+	}
+	if !strings.HasPrefix(theme.logoDataURI, "data:image/svg+xml;base64,") {
+		t.Errorf("expected the logo as an svg data uri, got %.40q", theme.logoDataURI)
+	}
+
+	for _, contents := range []string{
+		`{"link_color": "#AAAAAA"}`,
+		`{"rule_color": "white"}`,
+		`{"logo_max_width": 0}`,
+		`{"logo_max_width": 161}`,
+		`{"logo_max_height": 57}`,
+		`{"logo": "missing.png"}`,
+		`{"link_color": 1}`,
+	} {
+		if _, err := load(contents); err == nil {
+			t.Errorf("expected an error for the theme %s", contents)
+		}
+	}
+
+	if _, err := loadLabelTheme(filepath.Join(directory, "missing.json")); !os.IsNotExist(err) {
+		t.Errorf("expected a missing theme file to be an error, got %v", err)
+	}
+}
+
+func TestLogoBesideCompanyName(t *testing.T) {
+	previous := labelTheme
+	defer func() { labelTheme = previous }()
+	labelTheme = defaultLabelTheme()
+	labelTheme.logoDataURI = "data:image/svg+xml;base64,"
+
+	template := LabelTemplateData{BroadbandData: BroadbandData{
+		CompanyName:     "The Northern Neck and Middle Peninsula Rural Broadband Cooperative",
+		DataServiceName: "MaxSpeed 100",
+		FixedOrMobile:   "Fixed",
+	}}
+	rows, err := providerBlock(template)
+	if err != nil {
+		t.Fatal(err)
+	}
+	label := BroadbandConsumerLabel{columnLayout: standardColumn}
+	placed := label.placeSection(rows)
+
+	logoX, companyLines := 0, 0
+	for _, element := range placed {
+		if element.kind == placedImage {
+			logoX = element.x
+		}
+	}
+	for _, element := range placed {
+		if element.kind == placedText && element.style == labelCompanyName {
+			companyLines++
+			if right := element.x + measureText(element.text, element.style); right > logoX {
+				t.Errorf("the company name line %q ends at %d, past the logo at %d", element.text, right, logoX)
+			}
+		}
+	}
+	if logoX == 0 || companyLines < 2 {
+		t.Errorf("expected the logo and the company name wrapped beside it, got %d lines", companyLines)
+	}
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"os"
+	"time"
+)
+
+// watchPollInterval is how often the watched files are checked for changes. A
+// change is acted on once the files have been left alone for an interval.
+const watchPollInterval = 500 * time.Millisecond
+
+// fileStamp is the modification time and size of a file, it is zero for a
+// missing file.
+type fileStamp struct {
+	modTime time.Time
+	size    int64
+}
+
+func statFiles(files []string) map[string]fileStamp {
+	stamps := make(map[string]fileStamp, len(files))
+	for _, file := range files {
+		if info, err := os.Stat(file); err == nil {
+			stamps[file] = fileStamp{info.ModTime(), info.Size()}
+		} else {
+			stamps[file] = fileStamp{}
+		}
+	}
+	return stamps
+}
+
+func sameStamps(a, b map[string]fileStamp) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for file, stamp := range a {
+		if other, ok := b[file]; !ok || !stamp.modTime.Equal(other.modTime) || stamp.size != other.size {
+			return false
+		}
+	}
+	return true
+}
+
+// watchFiles polls the files returned by files every interval and calls
+// changed when one of them is written, created or removed. Editors can save a
+// file in several writes, so changed is only called once the files stay the
+// same for an interval. files is called again after each change, as a changed
+// config file can name other files. It returns when stop is closed.
+func watchFiles(files func() []string, interval time.Duration, changed func(), stop <-chan struct{}) {
+	stamps := statFiles(files())
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+
+		current := statFiles(files())
+		if sameStamps(stamps, current) {
+			continue
+		}
+		for settled := false; !settled; {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+			}
+			next := statFiles(files())
+			settled = sameStamps(current, next)
+			current = next
+		}
+		changed()
+		stamps = statFiles(files())
+	}
+}
+
+// settingsFiles lists the files the settings are read from: the csv file, the
+// config file and the theme file.
+func settingsFiles() []string {
+	files := []string{csvFileName}
+	if configFileInUse != "" {
+		files = append(files, configFileInUse)
+	} else {
+		// a config file can be added to the working directory while watching
+		files = append(files, defaultConfigFileNames...)
+	}
+	if themeFileName != "" {
+		files = append(files, themeFileName)
+	}
+	return files
+}
+
+// watchGenerate generates the labels, then again each time the csv, config or
+// theme file is saved, until it is interrupted. The flags are parsed again
+// before each run so config changes apply. Only the labels of changed plans
+// are written again, see labelCache. A csv with errors is reported in full
+// and the labels are left as they are until it is fixed.
+func watchGenerate(args []string) error {
+	var watch bool
+	run := func() {
+		result, err := validateCSV(csvFileName, commandLabelSettings())
+		if err != nil {
+			logError(err)
+			return
+		}
+		if len(result.Errors) > 0 {
+			for _, err := range result.Errors {
+				logError(err)
+			}
+			logInfo("%d of %d rows have errors, the labels weren't generated", len(result.Errors), result.Rows)
+			return
+		}
+		if err := generateLabels(); err != nil {
+			logError(err)
+		}
+	}
+
+	run()
+	logInfo("watching %s for changes", csvFileName)
+	watchFiles(settingsFiles, watchPollInterval, func() {
+		if err := parseGenerateFlags(args, &watch); err != nil {
+			logError(err)
+			return
+		}
+		logInfo("a watched file changed, generating the labels of %s again", csvFileName)
+		run()
+	}, make(chan struct{}))
+	return nil
+}
@@ -5,66 +5,75 @@ import (
 	"io"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
+	"time"
 )
 
-// written by ChatGPT. :)
-func zipUpLabels(outputDirectory, zipName string) error {
+// zipUpLabels writes the labels of a group and their manifest to a zip file in
+// the group directory. Only the labels generated by this run are added, any
+// other files in the directory are left out. The entries are sorted by name
+// and all have the manifest time, so the same labels always give the same zip.
+func zipUpLabels(group *labelGroup, manifest labelManifest) error {
+	zipName := group.zipName
 	if !strings.HasSuffix(zipName, ".zip") {
 		zipName += ".zip"
 	}
 
-	zipFile, err := os.Create(filepath.Join(outputDirectory, zipName))
+	zipFile, err := os.Create(filepath.Join(group.directory, zipName))
 	if err != nil {
 		return err
 	}
 	defer zipFile.Close()
 
 	zipWriter := zip.NewWriter(zipFile)
-	defer zipWriter.Close()
 
-	err = filepath.Walk(outputDirectory, func(path string, info os.FileInfo, err error) error {
-		if err != nil {
-			return err
-		}
-
-		if !strings.HasSuffix(path, ".svg") {
-			return nil
-		}
-
-		header, err := zip.FileInfoHeader(info)
-		if err != nil {
-			return err
-		}
+	entries := []string{manifestFileName}
+	for _, label := range group.labels {
+		entries = append(entries, label.fileName)
+	}
+	sort.Strings(entries)
 
-		header.Name, err = filepath.Rel(outputDirectory, path)
+	for _, name := range entries {
+		entry, err := zipWriter.CreateHeader(zipHeader(name, manifest.sourceDate))
 		if err != nil {
 			return err
 		}
 
-		if info.IsDir() {
-			header.Name += "/"
+		if name == manifestFileName {
+			err = manifest.writeTo(entry)
+		} else {
+			err = copyFileTo(entry, filepath.Join(group.directory, name))
 		}
-
-		entry, err := zipWriter.CreateHeader(header)
 		if err != nil {
 			return err
 		}
+	}
 
-		if !info.IsDir() {
-			file, err := os.Open(path)
-			if err != nil {
-				return err
-			}
-			defer file.Close()
+	if err := zipWriter.Close(); err != nil {
+		return err
+	}
+	return zipFile.Close()
+}
 
-			_, err = io.Copy(entry, file)
-			if err != nil {
-				return err
-			}
-		}
+// zipHeader only sets fields that are the same from one run to the next.
+func zipHeader(name string, modified time.Time) *zip.FileHeader {
+	header := &zip.FileHeader{
+		Name:     name,
+		Method:   zip.Deflate,
+		Modified: modified,
+	}
+	header.SetMode(0644)
+	return header
+}
+
+func copyFileTo(writer io.Writer, fileName string) error {
+	file, err := os.Open(fileName)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
 
-		return nil
-	})
+	_, err = io.Copy(writer, file)
 	return err
 }
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestZipUpLabelsManifest(t *testing.T) {
+	directory := t.TempDir()
+	group := &labelGroup{
+		directory: directory,
+		zipName:   "labels",
+		labels: []labelRecord{{
+			fileName:    "F12345000000000000051.svg",
+			planID:      "F12345000000000000051",
+			company:     "Greystar",
+			serviceName: "Fiber",
+			csvRow:      2,
+		}},
+	}
+	if err := os.WriteFile(filepath.Join(directory, group.labels[0].fileName), []byte("<svg></svg>"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	// files not generated by the run are left out of the zip
+	if err := os.WriteFile(filepath.Join(directory, "stale.svg"), []byte("<svg></svg>"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	generated := time.Date(2024, 4, 8, 16, 20, 0, 0, time.UTC)
+	manifest := newLabelManifest(group, "bcd.csv", "input-hash", labelDates{source: defaultSourceDate, generated: generated})
+	if err := zipUpLabels(group, manifest); err != nil {
+		t.Fatalf("zipUpLabels returned an error: %v", err)
+	}
+
+	archive, err := zip.OpenReader(filepath.Join(directory, "labels.zip"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer archive.Close()
+
+	var names []string
+	var zipped labelManifest
+	for _, file := range archive.File {
+		names = append(names, file.Name)
+		if !file.Modified.Equal(defaultSourceDate) {
+			t.Errorf("%s is dated %v, expected the source date %v", file.Name, file.Modified, defaultSourceDate)
+		}
+		if file.Name != manifestFileName {
+			continue
+		}
+		reader, err := file.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = json.NewDecoder(reader).Decode(&zipped)
+		reader.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if len(names) != 2 || names[0] != "F12345000000000000051.svg" || names[1] != manifestFileName {
+		t.Errorf("zip contains %v, expected the label and %s", names, manifestFileName)
+	}
+
+	expected := labelManifestFile{
+		FileName:    "F12345000000000000051.svg",
+		PlanID:      "F12345000000000000051",
+		Company:     "Greystar",
+		ServiceName: "Fiber",
+		CsvRow:      2,
+		// sha256 of <svg></svg>
+		SHA256: "b12e0d83ce2357d80b89c57694814d0a3abdaf8c40724f2049af8b7f01b7812b",
+	}
+	if len(zipped.Files) != 1 {
+		t.Fatalf("manifest has %d files, expected 1", len(zipped.Files))
+	}
+	if zipped.Files[0] != expected || zipped.InputSHA256 != "input-hash" || zipped.GeneratedAt != "2024-04-08T16:20:00Z" {
+		t.Errorf("manifest is %+v, expected %+v", zipped, expected)
+	}
+}
+
+func TestSourceDate(t *testing.T) {
+	for epoch, expected := range map[string]time.Time{
+		"":           defaultSourceDate,
+		"1712593200": time.Date(2024, 4, 8, 16, 20, 0, 0, time.UTC),
+	} {
+		t.Setenv("SOURCE_DATE_EPOCH", epoch)
+		date, err := sourceDate()
+		if err != nil || !date.Equal(expected) {
+			t.Errorf("SOURCE_DATE_EPOCH %q gave %v (%v), expected %v", epoch, date, err, expected)
+		}
+	}
+
+	// the generation time is pinned by SOURCE_DATE_EPOCH, otherwise it is now
+	t.Setenv("SOURCE_DATE_EPOCH", "1712593200")
+	dates, err := currentLabelDates()
+	if err != nil || !dates.generated.Equal(time.Date(2024, 4, 8, 16, 20, 0, 0, time.UTC)) {
+		t.Errorf("SOURCE_DATE_EPOCH gave the generation time %v (%v)", dates.generated, err)
+	}
+	t.Setenv("SOURCE_DATE_EPOCH", "")
+	before := time.Now().Add(-time.Second)
+	dates, err = currentLabelDates()
+	if err != nil || !dates.source.Equal(defaultSourceDate) || dates.generated.Before(before) {
+		t.Errorf("expected the default source date and the current time, got %+v (%v)", dates, err)
+	}
+
+	t.Setenv("SOURCE_DATE_EPOCH", "yesterday")
+	if _, err := sourceDate(); err == nil {
+		t.Error("expected an error for a SOURCE_DATE_EPOCH that isn't a unix timestamp")
+	}
+}